@@ -1,19 +1,92 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"judge-worker/internal/artifact"
+	"judge-worker/internal/blob"
+	"judge-worker/internal/cancellation"
+	"judge-worker/internal/customrun"
 	"judge-worker/internal/job"
+	"judge-worker/internal/language"
 	"judge-worker/internal/postgres"
+	"judge-worker/internal/usertoken"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	maxBatchSize = 1000
+
+	// testPreviewBytes caps how much of a visible test's input and expected
+	// output is echoed back with its result.
+	testPreviewBytes = 1024
+
+	// maxSubmitBytes caps a single submission's request body, which may
+	// carry a base64-encoded archive.
+	maxSubmitBytes = 4 << 20
+
+	// maxBatchBytes caps a batch request's body. It is well below
+	// maxBatchSize full-sized submissions, which would not fit in memory.
+	maxBatchBytes = 64 << 20
+
+	// maxRunInputBytes caps the stdin a custom run may be given.
+	maxRunInputBytes = 1 << 20
 )
 
+// errProblemLookup is returned by validateJob when the problem could not be
+// looked up, which is the server's fault rather than the job's.
+var errProblemLookup = errors.New("problem lookup failed")
+
+type batchItemResult struct {
+	SubmissionID string `json:"submission_id"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+type submissionView struct {
+	*job.ResultEvent
+	Subtasks []job.SubtaskScore `json:"subtasks,omitempty"`
+}
+
+type testResultView struct {
+	job.TestResult
+	Input          string `json:"input,omitempty"`
+	ExpectedOutput string `json:"expected_output,omitempty"`
+}
+
 func main() {
 	db := postgres.New(getEnv("POSTGRES_DSN", "user=postgres password=postgres host=postgres dbname=leetcode sslmode=disable"))
 	defer db.Close()
 	postgres.Migrate(db)
 
+	rdb := redis.NewClient(&redis.Options{
+		Addr: getEnv("REDIS_ADDR", "redis:6379"),
+	})
+	defer rdb.Close()
+
+	store, err := artifact.Open(getEnv("ARTIFACT_STORE", ""))
+	if err != nil {
+		log.Fatalf("ARTIFACT_STORE: %v", err)
+	}
+	// ARTIFACTS_ADMIN_TOKEN is the admin token's name from when it only
+	// guarded artifacts; it is still read when ADMIN_TOKEN is not set.
+	access := submissionAccess{
+		adminToken: getEnv("ADMIN_TOKEN", getEnv("ARTIFACTS_ADMIN_TOKEN", "")),
+		userSecret: []byte(getEnv("USER_TOKEN_SECRET", "")),
+	}
+
 	http.HandleFunc("/submit", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -21,15 +94,34 @@ func main() {
 		}
 
 		var j job.Job
+		r.Body = http.MaxBytesReader(w, r.Body, maxSubmitBytes)
 		if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("Invalid request body"))
 			return
 		}
 
-		if j.SubmissionID == "" || j.UserID == "" || j.Language == "" || j.Tier == "" {
+		if err := unpackArchive(&j); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("missing required fields"))
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if err := validateJob(db, j); err != nil {
+			if errors.Is(err, errProblemLookup) {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		source, err := detachSource(&j)
+		if err != nil {
+			log.Println("marshal error:", err)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
@@ -40,7 +132,7 @@ func main() {
 			return
 		}
 
-		if err := postgres.InsertOutboxEntry(db, j, payload); err != nil {
+		if err := postgres.InsertOutboxEntry(db, j, payload, map[string][]byte{j.SourceHash: source}); err != nil {
 			log.Println("outboxinsert error:", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -48,10 +140,546 @@ func main() {
 		w.WriteHeader(http.StatusCreated)
 	})
 
+	http.HandleFunc("POST /submissions:batch", func(w http.ResponseWriter, r *http.Request) {
+		handleBatchSubmit(db, w, r)
+	})
+
+	http.HandleFunc("GET /submissions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handleSubmission(db, access, w, r, r.PathValue("id"))
+	})
+
+	http.HandleFunc("GET /submissions/{id}/tests", func(w http.ResponseWriter, r *http.Request) {
+		handleTestResults(db, access, w, r, r.PathValue("id"))
+	})
+
+	http.HandleFunc("DELETE /submissions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handleCancel(r.Context(), db, rdb, access, w, r, r.PathValue("id"))
+	})
+
+	http.HandleFunc("GET /submissions/{id}/artifacts", func(w http.ResponseWriter, r *http.Request) {
+		handleArtifacts(db, access, w, r, r.PathValue("id"))
+	})
+
+	http.HandleFunc("GET /submissions/{id}/artifacts/{name}", func(w http.ResponseWriter, r *http.Request) {
+		handleArtifact(db, store, access, w, r, r.PathValue("id"), r.PathValue("name"))
+	})
+
+	http.HandleFunc("POST /run", func(w http.ResponseWriter, r *http.Request) {
+		handleRun(r.Context(), rdb, w, r)
+	})
+
+	http.HandleFunc("GET /run/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handleRunResult(r.Context(), rdb, w, r.PathValue("id"))
+	})
+
 	log.Println("API running on :8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
+func handleBatchSubmit(db *sqlx.DB, w http.ResponseWriter, r *http.Request) {
+	var jobs []job.Job
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBytes)
+	if err := json.NewDecoder(r.Body).Decode(&jobs); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte("batch request body too large"))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request body"))
+		return
+	}
+
+	if len(jobs) > maxBatchSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte("too many submissions in batch"))
+		return
+	}
+
+	results := make([]batchItemResult, len(jobs))
+	entries := make([]postgres.OutboxEntry, 0, len(jobs))
+	seen := make(map[string]bool, len(jobs))
+	blobs := make(map[string][]byte)
+
+	for i, j := range jobs {
+		results[i].SubmissionID = j.SubmissionID
+
+		if err := unpackArchive(&j); err != nil {
+			results[i].Status = "invalid"
+			results[i].Error = err.Error()
+			continue
+		}
+
+		if err := validateJob(db, j); err != nil {
+			if errors.Is(err, errProblemLookup) {
+				log.Println("batch:", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			results[i].Status = "invalid"
+			results[i].Error = err.Error()
+			continue
+		}
+
+		if seen[j.SubmissionID] {
+			results[i].Status = "duplicate"
+			continue
+		}
+		seen[j.SubmissionID] = true
+
+		source, err := detachSource(&j)
+		if err != nil {
+			results[i].Status = "invalid"
+			results[i].Error = err.Error()
+			continue
+		}
+		blobs[j.SourceHash] = source
+
+		payload, err := json.Marshal(j)
+		if err != nil {
+			results[i].Status = "invalid"
+			results[i].Error = err.Error()
+			continue
+		}
+
+		entries = append(entries, postgres.OutboxEntry{
+			SubmissionID: j.SubmissionID,
+			UserID:       j.UserID,
+			Language:     j.Language,
+			Tier:         j.Tier,
+			Payload:      payload,
+		})
+	}
+
+	inserted, err := postgres.InsertOutboxEntries(db, entries, blobs)
+	if err != nil {
+		log.Println("batch outbox insert error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for i := range results {
+		if results[i].Status != "" {
+			continue
+		}
+		if inserted[results[i].SubmissionID] {
+			results[i].Status = "created"
+		} else {
+			results[i].Status = "duplicate"
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+// handleCancel cancels a submission wherever it currently is. A pending
+// outbox row is cancelled in place and its CANCELLED result recorded right
+// away; anything the relay already published is left to the workers via
+// the cancellation marker, and they report CANCELLED through the results
+// stream.
+func handleCancel(ctx context.Context, db *sqlx.DB, rdb *redis.Client, access submissionAccess, w http.ResponseWriter, r *http.Request, submissionID string) {
+	if allowed, _ := access.check(db, w, r, submissionID); !allowed {
+		return
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println("cancel begin error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	e, err := postgres.CancelPendingOutboxEntry(tx, submissionID)
+	if err != nil {
+		log.Println("cancel outbox error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if e != nil {
+		err := postgres.InsertResultEvent(tx, &job.ResultEvent{
+			SubmissionID: e.SubmissionID,
+			UserID:       e.UserID,
+			Tier:         e.Tier,
+			Language:     e.Language,
+			Status:       job.StatusCancelled,
+			CompletedAt:  time.Now(),
+		})
+		if err != nil {
+			log.Println("cancel result insert error:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println("cancel commit error:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"submission_id": submissionID, "status": job.StatusCancelled})
+		return
+	}
+	tx.Rollback()
+
+	entry, err := postgres.GetOutboxEntry(db, submissionID)
+	if err != nil {
+		log.Println("cancel lookup error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if entry == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	res, err := postgres.GetSubmission(db, submissionID)
+	if err != nil {
+		log.Println("cancel lookup error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if res != nil {
+		status := http.StatusConflict
+		if res.Status == job.StatusCancelled {
+			status = http.StatusOK
+		}
+		writeJSON(w, status, map[string]string{"submission_id": submissionID, "status": res.Status})
+		return
+	}
+
+	if err := cancellation.Request(ctx, rdb, submissionID); err != nil {
+		log.Println("cancel request error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"submission_id": submissionID, "status": "CANCELLING"})
+}
+
+// handleSubmission reports a submission's status: its final result once
+// judged, PENDING while it is still queued or running.
+func handleSubmission(db *sqlx.DB, access submissionAccess, w http.ResponseWriter, r *http.Request, submissionID string) {
+	if allowed, _ := access.check(db, w, r, submissionID); !allowed {
+		return
+	}
+
+	res, err := postgres.GetSubmission(db, submissionID)
+	if err != nil {
+		log.Println("submission lookup error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if res == nil {
+		entry, err := postgres.GetOutboxEntry(db, submissionID)
+		if err != nil {
+			log.Println("submission lookup error:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if entry == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"submission_id": submissionID, "status": "PENDING"})
+		return
+	}
+
+	subtasks, err := postgres.GetSubtaskScores(db, submissionID)
+	if err != nil {
+		log.Println("subtask scores lookup error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.CompileOutput = truncate(res.CompileOutput, job.CompileOutputBytes)
+	writeJSON(w, http.StatusOK, submissionView{ResultEvent: res, Subtasks: subtasks})
+}
+
+// handleTestResults lists the per-test results of a submission. Hidden
+// tests only reveal their verdict and resource usage, never their data.
+func handleTestResults(db *sqlx.DB, access submissionAccess, w http.ResponseWriter, r *http.Request, submissionID string) {
+	if allowed, _ := access.check(db, w, r, submissionID); !allowed {
+		return
+	}
+
+	results, err := postgres.GetTestResults(db, submissionID)
+	if err != nil {
+		log.Println("test results lookup error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if len(results) == 0 {
+		entry, err := postgres.GetOutboxEntry(db, submissionID)
+		if err != nil {
+			log.Println("test results lookup error:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if entry == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+
+	views := make([]testResultView, 0, len(results))
+	for _, r := range results {
+		v := testResultView{TestResult: r.TestResult}
+		if !r.Hidden {
+			v.Input = truncate(r.Input, testPreviewBytes)
+			v.ExpectedOutput = truncate(r.ExpectedOutput, testPreviewBytes)
+		}
+		views = append(views, v)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"submission_id": submissionID, "tests": views})
+}
+
+// submissionAccess decides who may read a submission's status, test results
+// and artifacts, or cancel it: the user who submitted it, presenting a token
+// signed with the user token secret, or an operator presenting the admin
+// token. Only operators see artifacts produced on hidden tests.
+type submissionAccess struct {
+	adminToken string
+	userSecret []byte
+}
+
+// check reports whether the request may see the submission and whether it
+// may also see hidden artifacts. It answers the request itself when access
+// is refused.
+func (a submissionAccess) check(db *sqlx.DB, w http.ResponseWriter, r *http.Request, submissionID string) (allowed, admin bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false, false
+	}
+	if a.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) == 1 {
+		return true, true
+	}
+	if len(a.userSecret) == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false, false
+	}
+
+	userID, err := usertoken.Verify(a.userSecret, token, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false, false
+	}
+
+	entry, err := postgres.GetOutboxEntry(db, submissionID)
+	if err != nil {
+		log.Println("access lookup error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false, false
+	}
+	if entry == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return false, false
+	}
+	if entry.UserID != userID {
+		w.WriteHeader(http.StatusForbidden)
+		return false, false
+	}
+	return true, false
+}
+
+func handleArtifacts(db *sqlx.DB, access submissionAccess, w http.ResponseWriter, r *http.Request, submissionID string) {
+	allowed, admin := access.check(db, w, r, submissionID)
+	if !allowed {
+		return
+	}
+
+	refs, err := postgres.ListArtifactRefs(db, submissionID)
+	if err != nil {
+		log.Println("artifact list error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	visible := make([]postgres.ArtifactRef, 0, len(refs))
+	for _, ref := range refs {
+		if ref.Hidden && !admin {
+			continue
+		}
+		visible = append(visible, ref)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"submission_id": submissionID, "artifacts": visible})
+}
+
+func handleArtifact(db *sqlx.DB, store artifact.Store, access submissionAccess, w http.ResponseWriter, r *http.Request, submissionID, name string) {
+	allowed, admin := access.check(db, w, r, submissionID)
+	if !allowed {
+		return
+	}
+
+	ref, err := postgres.GetArtifactRef(db, submissionID, name)
+	if err != nil {
+		log.Println("artifact lookup error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if ref == nil || (ref.Hidden && !admin) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if store == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("artifact store not configured"))
+		return
+	}
+
+	data, err := store.Get(r.Context(), ref.StoreKey)
+	if errors.Is(err, artifact.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("artifact read error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+// handleRun queues a custom-input run. It is not a submission: nothing is
+// written to Postgres and the result is only kept in redis for a while.
+func handleRun(ctx context.Context, rdb *redis.Client, w http.ResponseWriter, r *http.Request) {
+	var req customrun.Request
+	// Room for a submission-sized source next to the largest input.
+	r.Body = http.MaxBytesReader(w, r.Body, maxSubmitBytes+maxRunInputBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte("run request body too large"))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request body"))
+		return
+	}
+
+	if err := validateRun(req); err != nil {
+		status := http.StatusBadRequest
+		if len(req.Input) > maxRunInputBytes {
+			status = http.StatusRequestEntityTooLarge
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Println("run id error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	req.RunID = hex.EncodeToString(id)
+
+	if err := customrun.Enqueue(ctx, rdb, req); err != nil {
+		log.Println("run enqueue error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"run_id": req.RunID, "status": customrun.StatusQueued})
+}
+
+func handleRunResult(ctx context.Context, rdb *redis.Client, w http.ResponseWriter, runID string) {
+	res, err := customrun.GetResult(ctx, rdb, runID)
+	if err != nil {
+		log.Println("run result lookup error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+func validateJob(db *sqlx.DB, j job.Job) error {
+	if j.SubmissionID == "" || j.UserID == "" || j.Language == "" || j.Tier == "" {
+		return errors.New("missing required fields")
+	}
+	if j.Tier != "free" && j.Tier != "premium" {
+		return errors.New("unknown tier")
+	}
+	if _, ok := language.Lookup(j.Language); !ok {
+		return fmt.Errorf("unsupported language %q, expected one of %s", j.Language, strings.Join(language.Names(), ", "))
+	}
+	if j.MultiFile() {
+		if err := validateProject(j); err != nil {
+			return err
+		}
+	} else if j.Source == "" {
+		return errors.New("missing source")
+	}
+	if j.ProblemID != "" {
+		exists, err := postgres.ProblemExists(db, j.ProblemID)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errProblemLookup, err)
+		}
+		if !exists {
+			return fmt.Errorf("unknown problem %q", j.ProblemID)
+		}
+	}
+	return nil
+}
+
+func validateRun(req customrun.Request) error {
+	if req.UserID == "" || req.Language == "" {
+		return errors.New("missing required fields")
+	}
+	if _, ok := language.Lookup(req.Language); !ok {
+		return fmt.Errorf("unsupported language %q, expected one of %s", req.Language, strings.Join(language.Names(), ", "))
+	}
+	if req.Source == "" {
+		return errors.New("missing source")
+	}
+	if len(req.Input) > maxRunInputBytes {
+		return fmt.Errorf("input exceeds %d bytes", maxRunInputBytes)
+	}
+	return nil
+}
+
+// detachSource moves a job's source out of its payload: the job keeps only
+// the content hash, and the source is returned to be stored as a blob. The
+// blob of a multi-file submission is the JSON of its files.
+func detachSource(j *job.Job) ([]byte, error) {
+	source := []byte(j.Source)
+	if j.MultiFile() {
+		var err error
+		if source, err = json.Marshal(j.Files); err != nil {
+			return nil, err
+		}
+	}
+	j.SourceHash = blob.Hash(source)
+	j.Source, j.Files = "", nil
+	return source, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("encode response error:", err)
+	}
+}
+
 func getEnv(key, def string) string {
 	if v, ok := os.LookupEnv(key); ok {
 		return v
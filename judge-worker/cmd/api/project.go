@@ -0,0 +1,177 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"judge-worker/internal/job"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits of multi-file submissions. maxProjectBytes counts unpacked bytes,
+// so a small archive cannot expand into a huge project.
+const (
+	maxProjectFiles = 200
+	maxProjectBytes = 1 << 20
+)
+
+// unpackArchive replaces a submitted archive with the files it holds.
+func unpackArchive(j *job.Job) error {
+	if len(j.Archive) == 0 {
+		return nil
+	}
+	if j.Source != "" || len(j.Files) > 0 {
+		return errors.New("send either source, files or archive")
+	}
+
+	var files map[string]string
+	var err error
+	switch j.ArchiveFormat {
+	case "zip":
+		files, err = unpackZip(j.Archive)
+	case "tar":
+		files, err = unpackTar(bytes.NewReader(j.Archive))
+	case "tar.gz", "tgz":
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(j.Archive)); err == nil {
+			files, err = unpackTar(gz)
+		}
+	default:
+		return fmt.Errorf("unsupported archive format %q, expected zip, tar or tar.gz", j.ArchiveFormat)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid archive: %w", err)
+	}
+
+	j.Files, j.Archive, j.ArchiveFormat = files, nil, ""
+	return nil
+}
+
+func unpackZip(data []byte) (map[string]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	p := newProject()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			return nil, fmt.Errorf("%s: only regular files are allowed", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = p.add(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.files, nil
+}
+
+func unpackTar(r io.Reader) (map[string]string, error) {
+	tr := tar.NewReader(r)
+	p := newProject()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return p.files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+			if err := p.add(hdr.Name, tr); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%s: only regular files are allowed", hdr.Name)
+		}
+	}
+}
+
+// project collects unpacked files while enforcing the project limits.
+type project struct {
+	files map[string]string
+	size  int
+}
+
+func newProject() *project {
+	return &project{files: make(map[string]string)}
+}
+
+func (p *project) add(name string, r io.Reader) error {
+	name = strings.TrimPrefix(name, "./")
+	if _, dup := p.files[name]; dup {
+		return fmt.Errorf("%s: duplicate file", name)
+	}
+	if len(p.files) >= maxProjectFiles {
+		return fmt.Errorf("more than %d files", maxProjectFiles)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxProjectBytes-p.size)+1))
+	if err != nil {
+		return err
+	}
+	p.size += len(data)
+	if p.size > maxProjectBytes {
+		return fmt.Errorf("files exceed %d bytes", maxProjectBytes)
+	}
+
+	p.files[name] = string(data)
+	return nil
+}
+
+// validateProject checks the files of a multi-file submission: their number
+// and size, that every path stays inside the sandbox, and that the
+// entrypoint or build file is one of them.
+func validateProject(j job.Job) error {
+	if j.Source != "" {
+		return errors.New("send either source, files or archive")
+	}
+	if len(j.Files) == 0 {
+		return errors.New("entrypoint and build_file need files")
+	}
+	if j.Entrypoint == "" && j.BuildFile == "" {
+		return errors.New("missing entrypoint or build_file")
+	}
+	if len(j.Files) > maxProjectFiles {
+		return fmt.Errorf("more than %d files", maxProjectFiles)
+	}
+
+	size := 0
+	for name, data := range j.Files {
+		if !fs.ValidPath(name) || name == "." || strings.Contains(name, `\`) {
+			return fmt.Errorf("invalid file path %q", name)
+		}
+		if !utf8.ValidString(data) {
+			return fmt.Errorf("%s: files must be UTF-8 text", name)
+		}
+		size += len(data)
+	}
+	if size > maxProjectBytes {
+		return fmt.Errorf("files exceed %d bytes", maxProjectBytes)
+	}
+
+	for _, name := range []string{j.Entrypoint, j.BuildFile} {
+		if _, ok := j.Files[name]; name != "" && !ok {
+			return fmt.Errorf("%s is not one of the files", name)
+		}
+	}
+	return nil
+}
@@ -3,10 +3,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"judge-worker/internal/blob"
+	"judge-worker/internal/fleet"
 	"judge-worker/internal/job"
+	"judge-worker/internal/language"
 	"judge-worker/internal/postgres"
+	"judge-worker/internal/stream"
 	"log"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -14,10 +21,25 @@ import (
 )
 
 const (
-	pollInterval = 2 * time.Second
-	batchSize    = 50
+	pollInterval  = 2 * time.Second
+	batchSize     = 50
+	fleetInterval = time.Minute
+
+	// blobGCInterval is how often expired blobs are deleted, at most
+	// blobGCBatch per statement.
+	blobGCInterval = time.Hour
+	blobGCBatch    = 1000
 )
 
+var tiers = []string{"free", "premium"}
+
+// pool is one job stream the relay feeds. An empty language is the tier's
+// general pool, which takes every language without a pool of its own.
+type pool struct {
+	tier     string
+	language string
+}
+
 func main() {
 	ctx := context.Background()
 
@@ -29,34 +51,153 @@ func main() {
 	})
 	defer rdb.Close()
 
-	for _, s := range []struct{ stream, group string }{
-		{"free-stream", "workers-free"},
-		{"premium-stream", "workers-premium"},
-	} {
-		err := rdb.XGroupCreateMkStream(ctx, s.stream, s.group, "0").Err()
-		if err != nil && !isAlreadyExists(err) {
-			log.Fatalf("create group %s: %v", s.group, err)
+	dedicated := splitList(getEnv("LANGUAGE_POOLS", ""))
+	for _, lang := range dedicated {
+		if _, ok := language.Lookup(lang); !ok {
+			log.Fatalf("LANGUAGE_POOLS: unsupported language %q", lang)
+		}
+	}
+
+	pools := buildPools(dedicated)
+
+	for _, p := range pools {
+		for _, sg := range []struct{ stream, group string }{
+			{stream.JobStream(p.tier, p.language), stream.JobGroup(p.tier, p.language)},
+			{stream.RunStream(p.tier, p.language), stream.RunGroup(p.tier, p.language)},
+		} {
+			err := rdb.XGroupCreateMkStream(ctx, sg.stream, sg.group, "0").Err()
+			if err != nil && !isAlreadyExists(err) {
+				log.Fatalf("create group %s: %v", sg.group, err)
+			}
 		}
 	}
 
+	maxBacklog, err := strconv.Atoi(getEnv("RELAY_MAX_BACKLOG", "20"))
+	if err != nil {
+		log.Fatalf("RELAY_MAX_BACKLOG: %v", err)
+	}
+
+	promoteAfter, err := time.ParseDuration(getEnv("PROMOTE_AFTER", "2m"))
+	if err != nil {
+		log.Fatalf("PROMOTE_AFTER: %v", err)
+	}
+
+	claimCheckBytes, err := strconv.Atoi(getEnv("RELAY_CLAIM_CHECK_BYTES", "16384"))
+	if err != nil {
+		log.Fatalf("RELAY_CLAIM_CHECK_BYTES: %v", err)
+	}
+
+	blobRetention, err := time.ParseDuration(getEnv("BLOB_RETENTION", "168h"))
+	if err != nil {
+		log.Fatalf("BLOB_RETENTION: %v", err)
+	}
+
 	log.Println("Relay started, polling outbox every", pollInterval)
 
+	var fleetCheckedAt, blobsCollectedAt time.Time
 	for {
-		if err := poll(ctx, db, rdb); err != nil {
-			log.Println("poll error:", err)
+		if len(dedicated) > 0 && time.Since(fleetCheckedAt) > fleetInterval {
+			checkFleet(ctx, rdb, dedicated)
+			fleetCheckedAt = time.Now()
+		}
+		if blobRetention > 0 && time.Since(blobsCollectedAt) > blobGCInterval {
+			collectBlobs(db, blobRetention)
+			blobsCollectedAt = time.Now()
+		}
+		for _, p := range pools {
+			if err := poll(ctx, db, rdb, p, dedicated, maxBacklog, claimCheckBytes); err != nil {
+				log.Printf("poll error (%s): %v", stream.JobStream(p.tier, p.language), err)
+			}
+		}
+		if promoteAfter > 0 {
+			if err := promote(ctx, db, rdb, promoteAfter, dedicated, maxBacklog, claimCheckBytes); err != nil {
+				log.Println("promote error:", err)
+			}
 		}
 		time.Sleep(pollInterval)
 	}
 }
 
-func poll(ctx context.Context, db *sqlx.DB, rdb *redis.Client) error {
+// buildPools lists the pools of every tier: its general pool and one per
+// dedicated language.
+func buildPools(dedicated []string) []pool {
+	var pools []pool
+	for _, tier := range tiers {
+		pools = append(pools, pool{tier: tier})
+		for _, lang := range dedicated {
+			pools = append(pools, pool{tier: tier, language: lang})
+		}
+	}
+	return pools
+}
+
+// filter returns the languages whose entries the pool publishes, as
+// FetchAndLockPendingEntries takes them: a dedicated pool publishes its own
+// language, the general pool every language but the dedicated ones. The
+// list is never nil, since a nil array binds as NULL and matches nothing.
+func (p pool) filter(dedicated []string) ([]string, bool) {
+	if p.language != "" {
+		return []string{p.language}, true
+	}
+	return append([]string{}, dedicated...), false
+}
+
+// checkFleet warns about language pools that no live worker advertises,
+// since their streams would only grow until one comes up.
+func checkFleet(ctx context.Context, rdb *redis.Client, dedicated []string) {
+	counts, err := fleet.Languages(ctx, rdb)
+	if err != nil {
+		log.Println("fleet check error:", err)
+		return
+	}
+	for _, lang := range dedicated {
+		if counts[lang] == 0 {
+			log.Printf("warning: no live worker advertises %s, its pool streams are not being consumed", lang)
+		}
+	}
+}
+
+// collectBlobs deletes blobs older than retention that no submission
+// references, such as payloads passed by reference long since judged.
+func collectBlobs(db *sqlx.DB, retention time.Duration) {
+	var total int64
+	for {
+		n, err := postgres.DeleteExpiredBlobs(db, retention, blobGCBatch)
+		if err != nil {
+			log.Println("blob gc error:", err)
+			break
+		}
+		total += n
+		if n < blobGCBatch {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("blob gc: deleted %d expired blobs", total)
+	}
+}
+
+// poolLanguage returns the pool a language is routed to within its tier.
+func poolLanguage(lang string, dedicated []string) string {
+	if slices.Contains(dedicated, lang) {
+		return lang
+	}
+	return ""
+}
+
+// promote moves free submissions that have waited longer than after into
+// the premium lane. A copy already sitting in free-stream is harmless: the
+// worker that reaches it second loses the claim and acks it. Like poll, it
+// keeps each premium stream within maxBacklog undelivered messages; entries
+// that do not fit are promoted on a later round.
+func promote(ctx context.Context, db *sqlx.DB, rdb *redis.Client, after time.Duration, dedicated []string, maxBacklog, claimCheckBytes int) error {
 	tx, err := db.Beginx()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	entries, err := postgres.FetchAndLockPendingEntries(tx, batchSize)
+	entries, err := postgres.FetchAndLockAgedEntries(tx, "free", after, batchSize)
 	if err != nil {
 		return err
 	}
@@ -65,6 +206,8 @@ func poll(ctx context.Context, db *sqlx.DB, rdb *redis.Client) error {
 		return nil
 	}
 
+	// room is how many more messages each premium stream takes this round.
+	room := make(map[string]int)
 	for _, e := range entries {
 		var j job.Job
 		if err := json.Unmarshal(e.Payload, &j); err != nil {
@@ -72,18 +215,106 @@ func poll(ctx context.Context, db *sqlx.DB, rdb *redis.Client) error {
 			continue
 		}
 
-		stream := "free-stream"
-		if j.Tier == "premium" {
-			stream = "premium-stream"
+		lang := poolLanguage(j.Language, dedicated)
+		streamName := stream.JobStream("premium", lang)
+		free, ok := room[streamName]
+		if !ok {
+			backlog, err := stream.Backlog(ctx, rdb, streamName, stream.JobGroup("premium", lang))
+			if err != nil {
+				return err
+			}
+			free = batchSize
+			if backlog >= 0 {
+				free = min(free, maxBacklog-int(backlog))
+			}
+		}
+		if free <= 0 {
+			room[streamName] = 0
+			continue
+		}
+		room[streamName] = free - 1
+
+		j.Promoted = true
+		payload, err := json.Marshal(j)
+		if err != nil {
+			return err
+		}
+
+		values, err := jobValues(db, j.SubmissionID, payload, claimCheckBytes)
+		if err != nil {
+			return err
 		}
 
-		_, err := rdb.XAdd(ctx, &redis.XAddArgs{
-			Stream: stream,
+		_, err = rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream: streamName,
 			ID:     "*",
-			Values: map[string]interface{}{
-				"submission_id": j.SubmissionID,
-				"payload":       string(e.Payload),
-			},
+			Values: values,
+		}).Result()
+
+		if err != nil {
+			log.Printf("XADD failed promoting submission %s: %v", e.SubmissionID, err)
+			return err
+		}
+
+		if err := postgres.MarkOutboxEntryPromoted(tx, e.ID, payload); err != nil {
+			return err
+		}
+		log.Printf("promoted submission %s after waiting %s", e.SubmissionID, time.Since(e.CreatedAt).Round(time.Second))
+	}
+
+	return tx.Commit()
+}
+
+// poll publishes pending entries of one pool. It only tops the stream up to
+// maxBacklog undelivered messages: the rest wait in the outbox, where they
+// are handed out round-robin per user, instead of queueing FIFO in redis.
+func poll(ctx context.Context, db *sqlx.DB, rdb *redis.Client, p pool, dedicated []string, maxBacklog, claimCheckBytes int) error {
+	streamName := stream.JobStream(p.tier, p.language)
+
+	limit := batchSize
+	backlog, err := stream.Backlog(ctx, rdb, streamName, stream.JobGroup(p.tier, p.language))
+	if err != nil {
+		return err
+	}
+	if backlog >= 0 {
+		limit = min(limit, maxBacklog-int(backlog))
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	languages, inLanguages := p.filter(dedicated)
+	entries, err := postgres.FetchAndLockPendingEntries(tx, p.tier, languages, inLanguages, limit)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	for _, e := range entries {
+		var j job.Job
+		if err := json.Unmarshal(e.Payload, &j); err != nil {
+			log.Printf("unmarshal outbox entry %d: %v - skipping", e.ID, err)
+			continue
+		}
+
+		values, err := jobValues(db, j.SubmissionID, e.Payload, claimCheckBytes)
+		if err != nil {
+			return err
+		}
+
+		_, err = rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream: streamName,
+			ID:     "*",
+			Values: values,
 		}).Result()
 
 		if err != nil {
@@ -99,10 +330,42 @@ func poll(ctx context.Context, db *sqlx.DB, rdb *redis.Client) error {
 	return tx.Commit()
 }
 
+// jobValues builds the stream message of a job. Payloads larger than
+// claimCheckBytes are stored as a blob and only referenced by hash, so big
+// jobs do not sit in redis memory; the blob is written outside the outbox
+// transaction so it is readable before any worker sees the message.
+func jobValues(db *sqlx.DB, submissionID string, payload []byte, claimCheckBytes int) (map[string]interface{}, error) {
+	if len(payload) <= claimCheckBytes {
+		return map[string]interface{}{
+			"submission_id":     submissionID,
+			stream.PayloadField: string(payload),
+		}, nil
+	}
+
+	hash := blob.Hash(payload)
+	if err := postgres.PutBlobs(db, map[string][]byte{hash: payload}); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"submission_id":        submissionID,
+		stream.PayloadRefField: hash,
+	}, nil
+}
+
 func isAlreadyExists(err error) bool {
 	return err != nil && err.Error() == "BUSYGROUP Consumer Group name already exists"
 }
 
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnv(key, def string) string {
 	if v, ok := os.LookupEnv(key); ok {
 		return v
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestPoolFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		dedicated []string
+		pool      pool
+		want      []string
+		wantIn    bool
+	}{
+		{"general pool without dedicated pools", nil, pool{tier: "free"}, []string{}, false},
+		{"general pool with empty LANGUAGE_POOLS", splitList(""), pool{tier: "premium"}, []string{}, false},
+		{"general pool skips dedicated languages", []string{"python", "cpp"}, pool{tier: "free"}, []string{"python", "cpp"}, false},
+		{"dedicated pool", []string{"python", "cpp"}, pool{tier: "free", language: "cpp"}, []string{"cpp"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, in := tt.pool.filter(tt.dedicated)
+			if !reflect.DeepEqual(got, tt.want) || in != tt.wantIn {
+				t.Errorf("filter = %v, %v, want %v, %v", got, in, tt.want, tt.wantIn)
+			}
+
+			// A NULL array would make the language predicate NULL, so the
+			// pool would never select a pending entry.
+			v, err := pq.Array(got).Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v == nil {
+				t.Errorf("languages bind as NULL")
+			}
+		})
+	}
+}
+
+func TestBuildPoolsWithoutDedicatedLanguages(t *testing.T) {
+	pools := buildPools(splitList(""))
+	want := []pool{{tier: "free"}, {tier: "premium"}}
+	if !reflect.DeepEqual(pools, want) {
+		t.Fatalf("pools = %+v, want %+v", pools, want)
+	}
+
+	for _, p := range pools {
+		if languages, _ := p.filter(nil); languages == nil {
+			t.Errorf("%s general pool filters on a nil language list", p.tier)
+		}
+	}
+}
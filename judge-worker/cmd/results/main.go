@@ -74,6 +74,10 @@ func processMessage(ctx context.Context, rdb *redis.Client, db *sqlx.DB, msg red
 		log.Printf("msg %s: DB insert failed: %v — leaving in PEL", msg.ID, err)
 		return
 	}
+	if err := postgres.MarkOutboxEntryCompleted(db, ev.SubmissionID); err != nil {
+		log.Printf("msg %s: outbox completion failed: %v — leaving in PEL", msg.ID, err)
+		return
+	}
 
 	xack(ctx, rdb, msg.ID)
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"judge-worker/internal/postgres"
+	"log"
+	"net/url"
+)
+
+// artifactBytes caps each stored artifact; the rest is dropped and the
+// artifact marked truncated.
+const artifactBytes = 64 << 10
+
+// saveArtifact keeps a copy of some output of a submission for debugging.
+// Failures are only logged: artifacts never decide a verdict.
+func (w *worker) saveArtifact(ctx context.Context, submissionID, name string, data []byte, hidden bool) {
+	if w.artifacts == nil || len(data) == 0 {
+		return
+	}
+
+	truncated := len(data) > artifactBytes
+	if truncated {
+		data = data[:artifactBytes]
+	}
+
+	key := "submissions/" + url.PathEscape(submissionID) + "/" + name
+	if err := w.artifacts.Put(ctx, key, data); err != nil {
+		log.Printf("submission %s: storing artifact %s failed: %v", submissionID, name, err)
+		return
+	}
+
+	err := postgres.SaveArtifactRef(w.db, postgres.ArtifactRef{
+		SubmissionID: submissionID,
+		Name:         name,
+		StoreKey:     key,
+		SizeBytes:    len(data),
+		Truncated:    truncated,
+		Hidden:       hidden,
+	})
+	if err != nil {
+		log.Printf("submission %s: recording artifact %s failed: %v", submissionID, name, err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"judge-worker/internal/blob"
+	"judge-worker/internal/job"
+	"judge-worker/internal/postgres"
+	"judge-worker/internal/stream"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// blobCacheBytes bounds the blobs a worker keeps in memory. Rejudges and
+// the compile and run stages of one submission fetch the same ones.
+const blobCacheBytes = 64 << 20
+
+var errBlobMissing = errors.New("blob missing")
+
+// messagePayload returns the serialised job a stream message carries,
+// fetching it when the relay only left a reference to it.
+func (w *worker) messagePayload(msg redis.XMessage) (string, error) {
+	if payload, ok := msg.Values[stream.PayloadField].(string); ok {
+		return payload, nil
+	}
+	ref, ok := msg.Values[stream.PayloadRefField].(string)
+	if !ok {
+		return "", errors.New("missing payload field")
+	}
+
+	data, err := w.fetchBlob(ref)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// failMissingPayload finishes a submission whose message references a
+// payload that is gone, so it gets an INTERNAL_ERROR result instead of
+// never getting one. The job is rebuilt from its outbox entry as far as
+// the result needs it.
+func (w *worker) failMissingPayload(ctx context.Context, msg redis.XMessage, src source, submissionID string) {
+	j := job.Job{SubmissionID: submissionID, Tier: src.tier}
+	entry, err := postgres.GetOutboxEntry(w.db, submissionID)
+	if err != nil {
+		log.Printf("msg %v: outbox lookup failed: %v — leaving in PEL", msg.ID, err)
+		return
+	}
+	if entry != nil {
+		j.UserID, j.Language, j.Tier = entry.UserID, entry.Language, entry.Tier
+	}
+
+	if w.claim(ctx, msg, src, submissionID) {
+		w.finish(ctx, msg, src, createResultEvent(j, job.StatusInternalError))
+	}
+}
+
+// resolveSource fills in the source of a job that only references it by
+// hash, or its files for a multi-file submission. Jobs that still carry
+// their sources inline are left as they are.
+func (w *worker) resolveSource(j *job.Job) error {
+	if j.Source != "" || len(j.Files) > 0 || j.SourceHash == "" {
+		return nil
+	}
+
+	data, err := w.fetchBlob(j.SourceHash)
+	if err != nil {
+		return err
+	}
+	if j.MultiFile() {
+		if err := json.Unmarshal(data, &j.Files); err != nil {
+			return fmt.Errorf("files of %s: %w", j.SourceHash, err)
+		}
+		return nil
+	}
+	j.Source = string(data)
+	return nil
+}
+
+// fetchBlob returns the blob stored under hash, from memory when possible.
+func (w *worker) fetchBlob(hash string) ([]byte, error) {
+	if data, ok := w.blobs.Get(hash); ok {
+		return data, nil
+	}
+
+	data, err := postgres.GetBlob(w.db, hash)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errBlobMissing
+	}
+	if blob.Hash(data) != hash {
+		return nil, fmt.Errorf("blob %s is corrupt", hash)
+	}
+
+	w.blobs.Add(hash, data)
+	return data, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"judge-worker/internal/job"
+	"judge-worker/internal/language"
+	"judge-worker/internal/postgres"
+	"log"
+	"strconv"
+)
+
+// judgeVersion is part of every result cache key. Bump it whenever a change
+// to judging could alter verdicts, so results cached before are not reused.
+const judgeVersion = "3"
+
+// cacheableStatuses are verdicts that depend on nothing but the submission
+// and the problem. Time limits and failures depend on the machine, and a
+// runtime error may be a memory limit hit under the tier's effective limits.
+var cacheableStatuses = map[string]bool{
+	job.StatusAccepted:     true,
+	job.StatusWrongAnswer:  true,
+	job.StatusCompileError: true,
+}
+
+// resultCacheKey identifies everything a verdict depends on: the source, the
+// toolchain it is built with, the problem version, the tier whose limits it
+// runs under and the judge itself. It returns "" when the submission cannot
+// be cached.
+func (w *worker) resultCacheKey(j job.Job) string {
+	recipe, ok := language.Lookup(j.Language)
+	if !ok {
+		return ""
+	}
+
+	problemVersion := 0
+	if j.ProblemID != "" {
+		v, found, err := postgres.GetProblemVersion(w.db, j.ProblemID)
+		if err != nil {
+			log.Printf("submission %s: problem version lookup failed: %v", j.SubmissionID, err)
+			return ""
+		}
+		if !found {
+			return ""
+		}
+		problemVersion = v
+	}
+
+	source := j.Source
+	if j.MultiFile() {
+		// Maps marshal with sorted keys, so equal projects hash the same.
+		files, err := json.Marshal(j.Files)
+		if err != nil {
+			return ""
+		}
+		source = string(files)
+	}
+
+	h := sha256.New()
+	for _, part := range []string{
+		judgeVersion,
+		recipe.Name, recipe.Version,
+		j.ProblemID, strconv.Itoa(problemVersion),
+		j.Tier,
+		j.Entrypoint, j.BuildFile,
+		source,
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedResult returns the result of an identical earlier submission,
+// marked as cached, with its per-test results copied over. It returns nil
+// on a miss.
+func (w *worker) cachedResult(j job.Job, key string) *job.ResultEvent {
+	if key == "" {
+		return nil
+	}
+
+	c, err := postgres.GetCachedResult(w.db, key)
+	if err != nil {
+		log.Printf("submission %s: result cache lookup failed: %v", j.SubmissionID, err)
+		return nil
+	}
+	if c == nil || c.SubmissionID == j.SubmissionID {
+		return nil
+	}
+
+	if err := postgres.CopyJudgeDetails(w.db, c.SubmissionID, j.SubmissionID); err != nil {
+		log.Printf("submission %s: copying cached results failed: %v", j.SubmissionID, err)
+		return nil
+	}
+
+	res := createResultEvent(j, c.Result.Status)
+	res.ExecutionMs = c.Result.ExecutionMs
+	res.CPUMs = c.Result.CPUMs
+	res.WallMs = c.Result.WallMs
+	res.PeakRSSKB = c.Result.PeakRSSKB
+	res.ExitCode = c.Result.ExitCode
+	res.Signal = c.Result.Signal
+	res.Score = c.Result.Score
+	res.CompileOutput = c.Result.CompileOutput
+	res.TimeLimitMs = c.Result.TimeLimitMs
+	res.WallLimitMs = c.Result.WallLimitMs
+	res.MemoryLimitKB = c.Result.MemoryLimitKB
+	res.Cached = true
+	log.Printf("submission %s: reusing result of %s", j.SubmissionID, c.SubmissionID)
+	return res
+}
+
+// cacheResult remembers a freshly judged result for identical submissions.
+func (w *worker) cacheResult(key string, res *job.ResultEvent) {
+	if key == "" || res.Cached || !cacheableStatuses[res.Status] {
+		return
+	}
+	if err := postgres.SaveCachedResult(w.db, key, res); err != nil {
+		log.Printf("submission %s: caching result failed: %v", res.SubmissionID, err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"judge-worker/internal/customrun"
+	"judge-worker/internal/executor"
+	"judge-worker/internal/job"
+	"judge-worker/internal/language"
+	"judge-worker/internal/limits"
+	"log"
+	"time"
+)
+
+const (
+	customRunBlock = 5 * time.Second
+
+	// customRunOutputBytes caps how much of stdout and stderr is kept for
+	// the user; the result sits in redis, not in a database.
+	customRunOutputBytes = 64 << 10
+)
+
+// consumeCustomRuns serves POST /run requests until ctx is done. A run that
+// has started is finished even during shutdown, like judged submissions.
+func (w *worker) consumeCustomRuns(ctx context.Context) {
+	for ctx.Err() == nil {
+		req, err := customrun.Next(ctx, w.rdb, w.consumerID, customRunBlock)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("custom run read error: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if req == nil {
+			continue
+		}
+
+		runCtx := context.WithoutCancel(ctx)
+		res := w.customRun(runCtx, req)
+		if err := customrun.SaveResult(runCtx, w.rdb, res); err != nil {
+			log.Printf("custom run %s: saving result failed: %v", req.RunID, err)
+		}
+	}
+}
+
+// customRun builds the code and runs it once on the user's input under the
+// language's default limits.
+func (w *worker) customRun(ctx context.Context, req *customrun.Request) *customrun.Result {
+	res := &customrun.Result{RunID: req.RunID, Status: job.StatusInternalError}
+	defer func() { res.CompletedAt = time.Now() }()
+
+	recipe, ok := language.Lookup(req.Language)
+	if !ok {
+		log.Printf("custom run %s: unsupported language %q", req.RunID, req.Language)
+		return res
+	}
+
+	sb, err := w.exec.NewSandbox(recipe, req.Source)
+	if err != nil {
+		log.Printf("custom run %s: sandbox setup failed: %v", req.RunID, err)
+		return res
+	}
+	defer sb.Close()
+
+	compiled, err := sb.Compile(ctx, compileTimeout)
+	if err != nil {
+		log.Printf("custom run %s: compile failed: %v", req.RunID, err)
+		return res
+	}
+	if !compiled.OK() {
+		res.Status = job.StatusCompileError
+		fillCustomRunOutput(res, compiled)
+		return res
+	}
+
+	// Custom runs carry no tier and get the free tier's limits.
+	lim := limits.Effective(baseTimeLimitMs, baseMemoryLimitKB, recipe, "free")
+	out, err := sb.Run(ctx, []byte(req.Input), lim)
+	if err != nil {
+		log.Printf("custom run %s: run failed: %v", req.RunID, err)
+		return res
+	}
+
+	res.Status = runVerdict(out)
+	if res.Status == job.StatusAccepted {
+		res.Status = customrun.StatusOK
+	}
+	fillCustomRunOutput(res, out)
+	return res
+}
+
+func fillCustomRunOutput(res *customrun.Result, out *executor.Outcome) {
+	res.Stdout = truncateOutput(res, out.Stdout)
+	res.Stderr = truncateOutput(res, out.Stderr)
+	res.ExitCode = out.ExitCode
+	res.Signal = out.Signal
+	res.CPUMs = int(out.CPUTime.Milliseconds())
+	res.WallMs = int(out.WallTime.Milliseconds())
+	res.PeakRSSKB = out.PeakMemoryKB
+}
+
+func truncateOutput(res *customrun.Result, b []byte) string {
+	if len(b) > customRunOutputBytes {
+		res.Truncated = true
+		b = b[:customRunOutputBytes]
+	}
+	return string(b)
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"judge-worker/internal/checker"
+	"judge-worker/internal/executor"
+	"judge-worker/internal/interactor"
+	"judge-worker/internal/job"
+	"judge-worker/internal/language"
+	"judge-worker/internal/limits"
+	"judge-worker/internal/postgres"
+	"judge-worker/internal/problem"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	compileTimeout = 30 * time.Second
+
+	// The base limits of submissions without a problem, before language
+	// and tier adjustments.
+	baseTimeLimitMs   = 2000
+	baseMemoryLimitKB = 262144
+)
+
+// judge produces the final result of a submission, reusing the verdict of
+// an identical earlier submission when there is one.
+func (w *worker) judge(ctx context.Context, j job.Job, stage string) *job.ResultEvent {
+	key := w.resultCacheKey(j)
+	if res := w.cachedResult(j, key); res != nil {
+		return res
+	}
+
+	res := w.evaluate(ctx, j, stage)
+	if ctx.Err() == nil {
+		w.cacheResult(key, res)
+	}
+	return res
+}
+
+// evaluate judges a submission. The run stage starts from the compile
+// stage's artifact; otherwise the submission is built here.
+func (w *worker) evaluate(ctx context.Context, j job.Job, stage string) *job.ResultEvent {
+	var sb *executor.Sandbox
+	var res *job.ResultEvent
+	if stage == stageRun {
+		sb, res = w.restore(j)
+	} else {
+		sb, res = w.build(ctx, j)
+	}
+	if sb == nil {
+		return res
+	}
+	defer sb.Close()
+
+	res = w.execute(ctx, j, sb)
+	w.saveArtifact(ctx, j.SubmissionID, "sandbox.log", []byte(sb.Sanitize(sb.Log())), false)
+	return res
+}
+
+// build prepares a sandbox with the compiled submission. When the build
+// does not succeed it returns no sandbox and the submission's final result.
+func (w *worker) build(ctx context.Context, j job.Job) (*executor.Sandbox, *job.ResultEvent) {
+	recipe, ok := recipeFor(j)
+	if !ok {
+		log.Printf("submission %s: unsupported language %q", j.SubmissionID, j.Language)
+		return nil, createResultEvent(j, job.StatusInternalError)
+	}
+
+	var sb *executor.Sandbox
+	var err error
+	if j.MultiFile() {
+		sb, err = w.exec.NewProjectSandbox(recipe, j.Files)
+	} else {
+		sb, err = w.exec.NewSandbox(recipe, j.Source)
+	}
+	if err != nil {
+		log.Printf("submission %s: sandbox setup failed: %v", j.SubmissionID, err)
+		return nil, createResultEvent(j, job.StatusInternalError)
+	}
+
+	compiled, err := sb.Compile(ctx, compileTimeout)
+	if err != nil {
+		w.saveArtifact(ctx, j.SubmissionID, "sandbox.log", []byte(sb.Sanitize(sb.Log())), false)
+		sb.Close()
+		return nil, failedRun(j, err)
+	}
+	// Submitters see the log, so it names files relative to the sandbox
+	// as the inline compile error does.
+	w.saveArtifact(ctx, j.SubmissionID, "compile.log", []byte(sb.Sanitize(slices.Concat(compiled.Stdout, compiled.Stderr))), false)
+	if !compiled.OK() {
+		w.saveArtifact(ctx, j.SubmissionID, "sandbox.log", []byte(sb.Sanitize(sb.Log())), false)
+		res := createResultEvent(j, job.StatusCompileError)
+		res.CompileOutput = compileMessage(sb, compiled)
+		sb.Close()
+		return nil, res
+	}
+	return sb, nil
+}
+
+// recipeFor returns how the job's language is built and run, adapted to its
+// files when it is a multi-file submission.
+func recipeFor(j job.Job) (language.Recipe, bool) {
+	recipe, ok := language.Lookup(j.Language)
+	if !ok || !j.MultiFile() {
+		return recipe, ok
+	}
+
+	entrypoint := j.Entrypoint
+	if entrypoint == "" {
+		entrypoint = recipe.SourceFile
+	}
+	files := make([]string, 0, len(j.Files))
+	for name := range j.Files {
+		files = append(files, name)
+	}
+	return recipe.Project(files, entrypoint, j.BuildFile), true
+}
+
+// compileMessage is what users are shown of a failed build. Compilers write
+// diagnostics to stderr; a few only use stdout, which is the fallback.
+func compileMessage(sb *executor.Sandbox, compiled *executor.Outcome) string {
+	raw := compiled.Stderr
+	if len(bytes.TrimSpace(raw)) == 0 {
+		raw = compiled.Stdout
+	}
+	if compiled.TimedOut {
+		raw = []byte("compilation timed out")
+	}
+
+	msg := sb.Sanitize(raw)
+	if len(msg) > job.CompileOutputBytes {
+		msg = strings.ToValidUTF8(msg[:job.CompileOutputBytes], "") + "\n... (truncated)"
+	}
+	return msg
+}
+
+// restore unpacks the artifact the compile stage stored for the submission.
+func (w *worker) restore(j job.Job) (*executor.Sandbox, *job.ResultEvent) {
+	recipe, ok := recipeFor(j)
+	if !ok {
+		log.Printf("submission %s: unsupported language %q", j.SubmissionID, j.Language)
+		return nil, createResultEvent(j, job.StatusInternalError)
+	}
+
+	archive, err := postgres.GetBuildArtifact(w.db, j.SubmissionID)
+	if err != nil || archive == nil {
+		log.Printf("submission %s: build artifact unavailable: %v", j.SubmissionID, err)
+		return nil, createResultEvent(j, job.StatusInternalError)
+	}
+
+	sb, err := w.exec.RestoreSandbox(recipe, archive)
+	if err != nil {
+		log.Printf("submission %s: restoring build artifact failed: %v", j.SubmissionID, err)
+		return nil, createResultEvent(j, job.StatusInternalError)
+	}
+	return sb, nil
+}
+
+// execute runs a built submission against its problem's tests and records
+// a result row per test that ran. Problems without subtasks stop at the first
+// failing test; scored ones run every test. Submissions without a problem
+// are run once on empty input.
+func (w *worker) execute(ctx context.Context, j job.Job, sb *executor.Sandbox) *job.ResultEvent {
+	recipe := sb.Recipe()
+
+	if j.ProblemID == "" {
+		lim := limits.Effective(baseTimeLimitMs, baseMemoryLimitKB, recipe, j.Tier)
+		out, err := sb.Run(ctx, nil, lim)
+		if err != nil {
+			return failedRun(j, err)
+		}
+		res := createResultEvent(j, runVerdict(out))
+		addUsage(res, out)
+		setLimits(res, lim)
+		return res
+	}
+
+	p, err := w.loadProblem(j.ProblemID)
+	if err != nil || p == nil {
+		log.Printf("submission %s: problem %s unavailable: %v", j.SubmissionID, j.ProblemID, err)
+		return createResultEvent(j, job.StatusInternalError)
+	}
+
+	test, closeTester, err := w.testerFor(ctx, p, sb)
+	if err != nil {
+		return failedRun(j, err)
+	}
+	defer closeTester()
+
+	lim := limits.Effective(p.TimeLimitMs, p.MemoryLimitKB, recipe, j.Tier)
+
+	// Without subtasks the first failure decides the verdict; with them
+	// every test counts towards the score.
+	runs, err := w.runTests(ctx, p.Tests, test, lim, len(p.Subtasks) == 0)
+	if err != nil {
+		return failedRun(j, err)
+	}
+
+	res := createResultEvent(j, job.StatusAccepted)
+	setLimits(res, lim)
+	results := make([]job.TestResult, 0, len(runs))
+
+	for _, r := range runs {
+		tc, out, verdict := r.tc, r.out, r.verdict
+
+		if verdict != job.StatusAccepted {
+			prefix := fmt.Sprintf("test-%d", tc.Index)
+			w.saveArtifact(ctx, j.SubmissionID, prefix+".stdout", out.Stdout, tc.Hidden)
+			w.saveArtifact(ctx, j.SubmissionID, prefix+".stderr", out.Stderr, tc.Hidden)
+		}
+
+		var diff *job.Diff
+		if verdict == job.StatusWrongAnswer && !tc.Hidden && !p.Interactive && checker.Diffable(p.Checker) {
+			diff = checker.FirstDifference(tc.ExpectedOutput, string(out.Stdout))
+		}
+
+		addUsage(res, out)
+		results = append(results, job.TestResult{
+			TestIndex:    tc.Index,
+			ProblemID:    p.ID,
+			Hidden:       tc.Hidden,
+			Verdict:      verdict,
+			Score:        r.score,
+			CPUMs:        int(out.CPUTime.Milliseconds()),
+			WallMs:       int(out.WallTime.Milliseconds()),
+			PeakMemoryKB: out.PeakMemoryKB,
+			ExitCode:     out.ExitCode,
+			Diff:         diff,
+		})
+
+		if verdict != job.StatusAccepted && res.Status == job.StatusAccepted {
+			res.Status = verdict
+		}
+	}
+
+	if err := postgres.SaveTestResults(w.db, j.SubmissionID, results); err != nil {
+		log.Printf("submission %s: saving test results failed: %v", j.SubmissionID, err)
+	}
+
+	if len(p.Subtasks) > 0 {
+		scores := subtaskScores(p, results)
+		for _, s := range scores {
+			res.Score += s.Score
+		}
+		if err := postgres.SaveSubtaskScores(w.db, j.SubmissionID, scores); err != nil {
+			log.Printf("submission %s: saving subtask scores failed: %v", j.SubmissionID, err)
+		}
+	}
+	return res
+}
+
+// subtaskScores applies each subtask's rule to the tests that belong to it.
+func subtaskScores(p *problem.Problem, results []job.TestResult) []job.SubtaskScore {
+	subtaskOf := make(map[int]int, len(p.Tests))
+	for _, tc := range p.Tests {
+		subtaskOf[tc.Index] = tc.Subtask
+	}
+	bySubtask := make(map[int][]float64)
+	for _, r := range results {
+		st := subtaskOf[r.TestIndex]
+		bySubtask[st] = append(bySubtask[st], r.Score)
+	}
+
+	scores := make([]job.SubtaskScore, 0, len(p.Subtasks))
+	for _, st := range p.Subtasks {
+		scores = append(scores, job.SubtaskScore{
+			SubtaskIndex: st.Index,
+			Points:       st.Points,
+			Score:        st.Score(bySubtask[st.Index]),
+		})
+	}
+	return scores
+}
+
+// addUsage charges one run to the submission. A submission is billed for
+// its most expensive run, and reports the exit status of the last one.
+func addUsage(res *job.ResultEvent, out *executor.Outcome) {
+	res.CPUMs = max(res.CPUMs, int(out.CPUTime.Milliseconds()))
+	res.WallMs = max(res.WallMs, int(out.WallTime.Milliseconds()))
+	res.PeakRSSKB = max(res.PeakRSSKB, out.PeakMemoryKB)
+	res.ExitCode = out.ExitCode
+	res.Signal = out.Signal
+	res.ExecutionMs = res.WallMs
+}
+
+// setLimits records the limits the submission ran under.
+func setLimits(res *job.ResultEvent, lim limits.Limits) {
+	res.TimeLimitMs = lim.TimeMs
+	res.WallLimitMs = lim.WallMs
+	res.MemoryLimitKB = lim.MemoryKB
+}
+
+func runVerdict(out *executor.Outcome) string {
+	switch {
+	case out.MemoryExceeded:
+		return job.StatusMemoryLimitExceeded
+	case out.TimedOut:
+		return job.StatusTimeLimitExceeded
+	case out.ExitCode != 0:
+		return job.StatusRuntimeError
+	}
+	return job.StatusAccepted
+}
+
+// testRun is how the submission did on one test.
+type testRun struct {
+	tc      problem.TestCase
+	out     *executor.Outcome
+	verdict string
+	score   float64
+}
+
+// cpuPool hands out the cores tests are pinned to. It is shared by every
+// job of the worker, and the reaper judges alongside the main loop, so a
+// core is taken for each test and returned after it; two runs never share
+// one.
+type cpuPool chan int
+
+func newCPUPool(cpus []int) cpuPool {
+	if len(cpus) == 0 {
+		return nil
+	}
+	p := make(cpuPool, len(cpus))
+	for _, cpu := range cpus {
+		p <- cpu
+	}
+	return p
+}
+
+// acquire waits for a free core until wait is done, and returns ctx pinned
+// to it along with the func that gives the core back. Without a pool ctx
+// is not pinned.
+func (p cpuPool) acquire(ctx, wait context.Context) (context.Context, func(), error) {
+	if p == nil {
+		return ctx, func() {}, nil
+	}
+	select {
+	case cpu := <-p:
+		return executor.WithCPU(ctx, cpu), func() { p <- cpu }, nil
+	case <-wait.Done():
+		return nil, nil, wait.Err()
+	}
+}
+
+// runTests runs the tests in order, side by side on the worker's test CPUs
+// with each run pinned to a core no other run holds, or one after another
+// when it has none. With stopAtFailure nothing after the first failing test is run:
+// later tests are not started and those already running are abandoned. It
+// returns the runs up to that test, in test order, exactly as running them
+// one by one would have.
+func (w *worker) runTests(ctx context.Context, tests []problem.TestCase, test tester, lim limits.Limits, stopAtFailure bool) ([]testRun, error) {
+	runs := make([]testRun, len(tests))
+	errs := make([]error, len(tests))
+	cancels := make([]context.CancelFunc, len(tests))
+
+	// Lanes waiting for a core stop waiting once every test that counts
+	// has started.
+	waiting, allStarted := context.WithCancel(ctx)
+	defer allStarted()
+
+	var mu sync.Mutex
+	// next is the first test not started yet, end the first one past the
+	// tests that count.
+	next, end := 0, len(tests)
+	stopAfter := func(i int) {
+		if i+1 >= end {
+			return
+		}
+		end = i + 1
+		for k := end; k < next; k++ {
+			cancels[k]()
+		}
+		allStarted()
+	}
+
+	var wg sync.WaitGroup
+	for lane := 0; lane < max(1, cap(w.cpus)); lane++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				laneCtx, release, err := w.cpus.acquire(ctx, waiting)
+				if err != nil {
+					return
+				}
+
+				mu.Lock()
+				i := next
+				if i >= end || ctx.Err() != nil {
+					mu.Unlock()
+					release()
+					return
+				}
+				testCtx, cancel := context.WithCancel(laneCtx)
+				cancels[i] = cancel
+				next++
+				if next >= end {
+					allStarted()
+				}
+				mu.Unlock()
+
+				out, verdict, score, err := test(testCtx, tests[i], lim)
+				cancel()
+				release()
+
+				mu.Lock()
+				runs[i] = testRun{tc: tests[i], out: out, verdict: verdict, score: score}
+				errs[i] = err
+				if err != nil || (stopAtFailure && verdict != job.StatusAccepted) {
+					stopAfter(i)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	for _, err := range errs[:end] {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return runs[:end], nil
+}
+
+// tester runs the submission on one test and judges the run.
+type tester func(ctx context.Context, tc problem.TestCase, lim limits.Limits) (*executor.Outcome, string, float64, error)
+
+// testerFor returns how the problem's tests are judged: an interactor run
+// next to the submission, or a plain run followed by the checker. The
+// returned func releases whatever was built for it.
+func (w *worker) testerFor(ctx context.Context, p *problem.Problem, sb *executor.Sandbox) (tester, func(), error) {
+	if p.Interactive {
+		it, err := interactor.New(ctx, w.exec, p.InteractorLanguage, p.InteractorSource)
+		if err != nil {
+			return nil, nil, err
+		}
+		test := func(ctx context.Context, tc problem.TestCase, lim limits.Limits) (*executor.Outcome, string, float64, error) {
+			r, err := it.Run(ctx, sb, tc.Input, tc.ExpectedOutput, lim)
+			if err != nil {
+				return nil, "", 0, err
+			}
+			switch verdict := runVerdict(r.Contestant); {
+			case r.Idle:
+				return r.Contestant, job.StatusIdlenessLimitExceeded, 0, nil
+			case verdict != job.StatusAccepted:
+				return r.Contestant, verdict, 0, nil
+			case !r.Accepted:
+				return r.Contestant, job.StatusWrongAnswer, 0, nil
+			}
+			return r.Contestant, job.StatusAccepted, 1, nil
+		}
+		return test, func() { it.Close() }, nil
+	}
+
+	chk, err := w.checkerFor(ctx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	test := func(ctx context.Context, tc problem.TestCase, lim limits.Limits) (*executor.Outcome, string, float64, error) {
+		out, err := sb.Run(ctx, []byte(tc.Input), lim)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		if verdict := runVerdict(out); verdict != job.StatusAccepted {
+			return out, verdict, 0, nil
+		}
+		v, err := chk.Check(ctx, tc.Input, tc.ExpectedOutput, string(out.Stdout))
+		if err != nil {
+			return nil, "", 0, err
+		}
+		if !v.Accepted {
+			return out, job.StatusWrongAnswer, v.Score, nil
+		}
+		return out, job.StatusAccepted, v.Score, nil
+	}
+	release := func() {
+		if c, ok := chk.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	return test, release, nil
+}
+
+// checkerFor returns the problem's checker. A custom checker is built in a
+// sandbox of its own, which the caller releases by closing it.
+func (w *worker) checkerFor(ctx context.Context, p *problem.Problem) (checker.Checker, error) {
+	if p.Checker != checker.Custom {
+		return checker.Builtin(p.Checker)
+	}
+	return checker.NewProgram(ctx, w.exec, p.CheckerLanguage, p.CheckerSource)
+}
+
+// failedRun reports a judge that could not finish. A cancelled context is
+// turned into CANCELLED by the caller, so only log real failures.
+func failedRun(j job.Job, err error) *job.ResultEvent {
+	if err != context.Canceled {
+		log.Printf("submission %s: execution failed: %v", j.SubmissionID, err)
+	}
+	return createResultEvent(j, job.StatusInternalError)
+}
@@ -3,13 +3,30 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	_ "expvar"
+	"fmt"
+	"judge-worker/internal/artifact"
+	"judge-worker/internal/blob"
+	"judge-worker/internal/cancellation"
+	"judge-worker/internal/customrun"
+	"judge-worker/internal/executor"
+	"judge-worker/internal/fleet"
 	"judge-worker/internal/job"
+	"judge-worker/internal/language"
 	"judge-worker/internal/postgres"
+	"judge-worker/internal/scheduler"
 	"judge-worker/internal/stream"
+	"judge-worker/internal/testdata"
 	"log"
-	"math/rand/v2"
+	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -21,8 +38,87 @@ const (
 	staleDuration = 90 * time.Second
 
 	reaperDuration = 30 * time.Second
+
+	idleBlock = 500 * time.Millisecond
+
+	advertiseInterval = 20 * time.Second
+
+	prefetchInterval = 5 * time.Minute
+)
+
+var errCancelled = errors.New("submission cancelled")
+
+// runningJobs tracks the jobs this worker is executing so a cancellation
+// broadcast can abort them through their context.
+type runningJobs struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+}
+
+func newRunningJobs() *runningJobs {
+	return &runningJobs{cancels: make(map[string]context.CancelCauseFunc)}
+}
+
+// start returns the context a job runs under. It deliberately does not
+// inherit shutdown cancellation from parent so in-flight jobs still finish
+// inside the termination grace period.
+func (r *runningJobs) start(parent context.Context, submissionID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(parent))
+
+	r.mu.Lock()
+	r.cancels[submissionID] = cancel
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.cancels, submissionID)
+		r.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+func (r *runningJobs) cancel(submissionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel, ok := r.cancels[submissionID]; ok {
+		cancel(errCancelled)
+	}
+}
+
+type worker struct {
+	rdb        *redis.Client
+	db         *sqlx.DB
+	consumerID string
+	exec       *executor.Executor
+	artifacts  artifact.Store
+	blobs      *blob.Cache
+	testdata   *testdata.Cache
+	cpus       cpuPool
+	running    *runningJobs
+}
+
+// Stages a worker can run. The default does everything in one go; compile
+// and run split judging across two pools joined by the run streams. Custom
+// input workers only serve POST /run and never judge submissions.
+const (
+	stageAll         = "all"
+	stageCompile     = "compile"
+	stageRun         = "run"
+	stageCustomInput = "custom-input"
 )
 
+// source is one stream this worker consumes, together with the tier its
+// jobs are judged as, the pipeline stage it serves and its share of the
+// worker's capacity.
+type source struct {
+	stream   string
+	group    string
+	tier     string
+	language string
+	stage    string
+	weight   int
+}
+
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -36,9 +132,34 @@ func main() {
 		cancel()
 	}()
 
-	streamName := getEnv("STREAM_NAME", "free-stream")
-	groupName := getEnv("GROUP_NAME", "workers-free")
-	workerTier := getEnv("WORKER_TIER", "free")
+	languages := splitList(getEnv("WORKER_LANGUAGES", ""))
+	if err := checkToolchains(languages); err != nil {
+		log.Fatalf("WORKER_LANGUAGES: %v", err)
+	}
+
+	stage := getEnv("WORKER_STAGE", stageAll)
+	if stage != stageAll && stage != stageCompile && stage != stageRun && stage != stageCustomInput {
+		log.Fatalf("WORKER_STAGE: unknown stage %q", stage)
+	}
+
+	weights := getEnv("WORKER_WEIGHTS", "")
+	if weights == "" && (len(languages) > 0 || stage != stageAll) {
+		weights = getEnv("WORKER_TIER", "free") + "=1"
+	}
+
+	sources, err := parseSources(weights, languages, stage)
+	if err != nil {
+		log.Fatalf("WORKER_WEIGHTS: %v", err)
+	}
+	if len(sources) == 0 {
+		sources = []source{{
+			stream: getEnv("STREAM_NAME", "free-stream"),
+			group:  getEnv("GROUP_NAME", "workers-free"),
+			tier:   getEnv("WORKER_TIER", "free"),
+			stage:  stageAll,
+			weight: 1,
+		}}
+	}
 
 	consumerID, _ := os.Hostname()
 
@@ -50,11 +171,236 @@ func main() {
 	db := postgres.New(getEnv("POSTGRES_DSN", "user=postgres password=postgres host=postgres dbname=leetcode sslmode=disable"))
 	defer db.Close()
 
-	log.Printf("Worker started | tier=%s | stream=%s | consumer=%s\n", workerTier, streamName, groupName)
+	ex, err := executor.New(getEnv("SCRATCH_DIR", filepath.Join(os.TempDir(), "judge")))
+	if err != nil {
+		log.Fatalf("executor: %v", err)
+	}
+
+	pools, err := parsePoolSizes(getEnv("WORKER_RUNTIME_POOL", ""))
+	if err != nil {
+		log.Fatalf("WORKER_RUNTIME_POOL: %v", err)
+	}
+	for _, lang := range language.Names() {
+		if size := pools[lang]; size > 0 {
+			recipe, _ := language.Lookup(lang)
+			if err := ex.Prewarm(recipe, size); err != nil {
+				log.Fatalf("WORKER_RUNTIME_POOL: %v", err)
+			}
+		}
+	}
+	defer ex.Close()
+
+	store, err := artifact.Open(getEnv("ARTIFACT_STORE", ""))
+	if err != nil {
+		log.Fatalf("ARTIFACT_STORE: %v", err)
+	}
+
+	var testCache *testdata.Cache
+	if dir := getEnv("TESTDATA_CACHE_DIR", filepath.Join(os.TempDir(), "judge-testdata")); dir != "" {
+		maxBytes, err := strconv.ParseInt(getEnv("TESTDATA_CACHE_BYTES", "1073741824"), 10, 64)
+		if err != nil || maxBytes <= 0 {
+			log.Fatalf("TESTDATA_CACHE_BYTES: must be a positive integer")
+		}
+		if testCache, err = testdata.Open(dir, maxBytes); err != nil {
+			log.Fatalf("TESTDATA_CACHE_DIR: %v", err)
+		}
+	}
+
+	prefetch, err := strconv.Atoi(getEnv("TESTDATA_PREFETCH", "20"))
+	if err != nil || prefetch < 0 {
+		log.Fatalf("TESTDATA_PREFETCH: must be a non-negative integer")
+	}
+
+	testCPUs, err := parseTestCPUs(getEnv("WORKER_TEST_PARALLELISM", "1"))
+	if err != nil {
+		log.Fatalf("WORKER_TEST_PARALLELISM: %v", err)
+	}
+
+	w := &worker{
+		rdb:        rdb,
+		db:         db,
+		consumerID: consumerID,
+		exec:       ex,
+		artifacts:  store,
+		blobs:      blob.NewCache(blobCacheBytes),
+		testdata:   testCache,
+		cpus:       newCPUPool(testCPUs),
+		running:    newRunningJobs(),
+	}
+
+	if stage == stageCustomInput {
+		if err := stream.EnsureConsumerGroup(ctx, rdb, customrun.Stream, customrun.Group); err != nil {
+			log.Fatalf("custom input group: %v", err)
+		}
+		log.Printf("Worker started | stage=%s | stream=%s | group=%s\n", stage, customrun.Stream, customrun.Group)
+		w.consumeCustomRuns(ctx)
+		return
+	}
+
+	// Counters such as the test data cache hit rate are served on
+	// /debug/vars.
+	if addr := getEnv("METRICS_ADDR", ""); addr != "" {
+		go func() {
+			log.Println("metrics server:", http.ListenAndServe(addr, nil))
+		}()
+	}
+
+	if w.testdata != nil && prefetch > 0 {
+		go w.prefetchTests(ctx, prefetch, prefetchInterval)
+	}
+
+	for _, src := range sources {
+		log.Printf("Worker started | tier=%s | stage=%s | stream=%s | group=%s | weight=%d\n", src.tier, src.stage, src.stream, src.group, src.weight)
+	}
+
+	go func() {
+		for id := range cancellation.Subscribe(ctx, rdb) {
+			w.running.cancel(id)
+		}
+	}()
+
+	if len(languages) > 0 {
+		go w.advertise(ctx, languages)
+	}
+
+	for _, src := range sources {
+		w.drainPending(ctx, src)
+		go w.reaper(ctx, src)
+	}
+
+	if len(sources) == 1 {
+		w.consumeSingle(ctx, sources[0])
+		return
+	}
+	w.consumeWeighted(ctx, sources)
+}
+
+// parseSources reads a shared-pool configuration such as "premium=3,free=1".
+// Each tier is consumed from its own stream and group, so shared workers
+// compete fairly with any dedicated deployments on the same streams. Workers
+// with languages read those languages' pool streams of every tier instead of
+// the tier's general stream. Run-stage workers read the matching run streams.
+func parseSources(spec string, languages []string, stage string) ([]source, error) {
+	pools := languages
+	if len(pools) == 0 {
+		pools = []string{""}
+	}
+
+	var sources []source
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		tier, w, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected tier=weight", part)
+		}
+		weight, err := strconv.Atoi(w)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("%q: weight must be a positive integer", part)
+		}
+
+		for _, lang := range pools {
+			src := source{
+				stream:   stream.JobStream(tier, lang),
+				group:    stream.JobGroup(tier, lang),
+				tier:     tier,
+				language: lang,
+				stage:    stage,
+				weight:   weight,
+			}
+			if stage == stageRun {
+				src.stream = stream.RunStream(tier, lang)
+				src.group = stream.RunGroup(tier, lang)
+			}
+			sources = append(sources, src)
+		}
+	}
+	return sources, nil
+}
+
+// parsePoolSizes reads a runtime pool configuration such as
+// "python=4,javascript=2": how many warm processes to keep per language.
+func parsePoolSizes(spec string) (map[string]int, error) {
+	sizes := make(map[string]int)
+	for _, part := range splitList(spec) {
+		lang, n, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected language=size", part)
+		}
+		size, err := strconv.Atoi(n)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%q: size must be a non-negative integer", part)
+		}
+		if _, ok := language.Lookup(lang); !ok {
+			return nil, fmt.Errorf("unsupported language %q", lang)
+		}
+		sizes[lang] = size
+	}
+	return sizes, nil
+}
+
+// parseTestCPUs picks the cores tests of one submission run on side by
+// side, one per test. A parallelism of 1 runs tests one after another on
+// whatever core the scheduler picks, which needs no cores of its own.
+func parseTestCPUs(spec string) ([]int, error) {
+	parallelism, err := strconv.Atoi(spec)
+	if err != nil || parallelism <= 0 {
+		return nil, fmt.Errorf("%q: must be a positive integer", spec)
+	}
+	if parallelism == 1 {
+		return nil, nil
+	}
+
+	cpus, err := executor.AvailableCPUs()
+	if err != nil {
+		return nil, err
+	}
+	if len(cpus) < parallelism {
+		log.Printf("WORKER_TEST_PARALLELISM: only %d cores available, running %d tests at a time", len(cpus), len(cpus))
+		parallelism = len(cpus)
+	}
+	return cpus[:parallelism], nil
+}
+
+// checkToolchains makes sure every advertised language is known and its
+// toolchain is installed, so a pool never claims jobs it cannot build.
+func checkToolchains(languages []string) error {
+	for _, lang := range languages {
+		recipe, ok := language.Lookup(lang)
+		if !ok {
+			return fmt.Errorf("unsupported language %q", lang)
+		}
+		for _, bin := range recipe.Binaries() {
+			if _, err := exec.LookPath(bin); err != nil {
+				return fmt.Errorf("%s: %v", lang, err)
+			}
+		}
+	}
+	return nil
+}
+
+// advertise keeps this worker's languages registered until ctx is done.
+func (w *worker) advertise(ctx context.Context, languages []string) {
+	ticker := time.NewTicker(advertiseInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := fleet.Advertise(ctx, w.rdb, w.consumerID, languages, 3*advertiseInterval); err != nil && ctx.Err() == nil {
+			log.Println("advertise error:", err)
+		}
 
-	drainPending(ctx, rdb, db, streamName, groupName, consumerID, workerTier)
-	go reaper(ctx, rdb, db, streamName, groupName, consumerID, workerTier)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
 
+func (w *worker) consumeSingle(ctx context.Context, src source) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -63,37 +409,111 @@ func main() {
 		default:
 		}
 
-		streams, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
-			Group:    groupName,
-			Consumer: consumerID,
-			Streams:  []string{streamName, ">"},
-			Count:    1,
-			Block:    5 * time.Second,
-		}).Result()
-
+		msg, err := w.readOne(ctx, src, 5*time.Second)
 		if err != nil {
 			if err == context.Canceled {
 				log.Println("XREADGROUP unblocked by context cancel - shutting down")
 				return
 			}
-			if err == redis.Nil {
+			log.Println("XREADGROUP error:", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if msg != nil {
+			w.processMessage(ctx, *msg, src)
+		}
+	}
+}
+
+// consumeWeighted serves several tier streams from one pool. Every turn goes
+// to the stream the weighted schedule picks, and falls through to the others
+// when it is empty so no capacity idles while any tier has a backlog.
+func (w *worker) consumeWeighted(ctx context.Context, sources []source) {
+	weights := make([]int, len(sources))
+	for i, src := range sources {
+		weights[i] = src.weight
+	}
+	sched := scheduler.NewWeighted(weights)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("context cancellation received, exiting worker gracefully")
+			return
+		default:
+		}
+
+		order := sched.Order()
+		served := false
+
+		for _, i := range order {
+			msg, err := w.readOne(ctx, sources[i], -1)
+			if err != nil {
+				if err == context.Canceled {
+					log.Println("XREADGROUP unblocked by context cancel - shutting down")
+					return
+				}
+				log.Printf("XREADGROUP error on %s: %v", sources[i].stream, err)
 				continue
 			}
+			if msg != nil {
+				w.processMessage(ctx, *msg, sources[i])
+				served = true
+				break
+			}
+		}
+
+		if served {
+			continue
+		}
+
+		// Everything is empty: wait briefly on the stream whose turn it was
+		// instead of spinning through non-blocking reads.
+		msg, err := w.readOne(ctx, sources[order[0]], idleBlock)
+		if err != nil {
+			if err == context.Canceled {
+				log.Println("XREADGROUP unblocked by context cancel - shutting down")
+				return
+			}
 			log.Println("XREADGROUP error:", err)
 			time.Sleep(time.Second)
 			continue
 		}
+		if msg != nil {
+			w.processMessage(ctx, *msg, sources[order[0]])
+		}
+	}
+}
+
+// readOne reads at most one new message for src. A negative block makes the
+// read return immediately. It returns nil when there is nothing to read.
+func (w *worker) readOne(ctx context.Context, src source, block time.Duration) (*redis.XMessage, error) {
+	streams, err := w.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    src.group,
+		Consumer: w.consumerID,
+		Streams:  []string{src.stream, ">"},
+		Count:    1,
+		Block:    block,
+	}).Result()
+
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
 
-		for _, s := range streams {
-			for _, msgs := range s.Messages {
-				processMessage(ctx, rdb, db, msgs, streamName, groupName, consumerID, workerTier)
-			}
+	for _, s := range streams {
+		if len(s.Messages) > 0 {
+			return &s.Messages[0], nil
 		}
 	}
+	return nil, nil
 }
 
-func drainPending(ctx context.Context, rdb *redis.Client, db *sqlx.DB, streamName, groupName, consumerId, workerTier string) {
-	log.Println("Draining pending messages from previous session...")
+func (w *worker) drainPending(ctx context.Context, src source) {
+	log.Printf("Draining pending messages on %s from previous session...", src.stream)
 
 	for {
 		select {
@@ -102,10 +522,10 @@ func drainPending(ctx context.Context, rdb *redis.Client, db *sqlx.DB, streamNam
 		default:
 		}
 
-		streams, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
-			Group:    groupName,
-			Consumer: consumerId,
-			Streams:  []string{streamName, "0"},
+		streams, err := w.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    src.group,
+			Consumer: w.consumerID,
+			Streams:  []string{src.stream, "0"},
 			Count:    10,
 			Block:    0,
 		}).Result()
@@ -120,17 +540,26 @@ func drainPending(ctx context.Context, rdb *redis.Client, db *sqlx.DB, streamNam
 		}
 
 		for _, msg := range msgs {
-			processMessage(ctx, rdb, db, msg, streamName, groupName, consumerId, workerTier)
+			w.processMessage(ctx, msg, src)
 		}
 	}
 	log.Println("Pending Drain Complete")
 }
 
-func processMessage(ctx context.Context, rdb *redis.Client, db *sqlx.DB, msg redis.XMessage, streamName, groupName, consumerId, workerTier string) {
-	payloadStr, ok := msg.Values["payload"].(string)
-	if !ok {
-		log.Printf("msg %v: missing payload field, acking to discard", msg.ID)
-		xack(ctx, rdb, streamName, groupName, msg.ID)
+func (w *worker) processMessage(ctx context.Context, msg redis.XMessage, src source) {
+	payloadStr, err := w.messagePayload(msg)
+	if err != nil {
+		if _, isRef := msg.Values[stream.PayloadRefField]; isRef && !errors.Is(err, errBlobMissing) {
+			log.Printf("msg %v: resolving payload failed: %v — leaving in PEL", msg.ID, err)
+			return
+		}
+		if submissionID, ok := msg.Values["submission_id"].(string); ok && errors.Is(err, errBlobMissing) {
+			log.Printf("msg %v: payload of submission %s is missing", msg.ID, submissionID)
+			w.failMissingPayload(ctx, msg, src, submissionID)
+			return
+		}
+		log.Printf("msg %v: %v, acking to discard", msg.ID, err)
+		xack(ctx, w.rdb, src.stream, src.group, msg.ID)
 		return
 	}
 
@@ -138,53 +567,215 @@ func processMessage(ctx context.Context, rdb *redis.Client, db *sqlx.DB, msg red
 
 	if err := json.Unmarshal([]byte(payloadStr), &j); err != nil {
 		log.Printf("msg %v: unmarshal failed: %v, acking to discard", msg.ID, err)
-		xack(ctx, rdb, streamName, groupName, msg.ID)
+		xack(ctx, w.rdb, src.stream, src.group, msg.ID)
+		return
+	}
+
+	if src.tier != "free" && src.tier != "premium" {
+		log.Printf("msg %v: unknown worker tier %s, acking to discard", msg.ID, src.tier)
+		xack(ctx, w.rdb, src.stream, src.group, msg.ID)
+		return
+	}
+
+	if err := w.resolveSource(&j); err != nil {
+		if !errors.Is(err, errBlobMissing) {
+			log.Printf("msg %v: resolving source failed: %v — leaving in PEL", msg.ID, err)
+			return
+		}
+		log.Printf("msg %v: source %s of submission %s is missing", msg.ID, j.SourceHash, j.SubmissionID)
+		if w.claim(ctx, msg, src, j.SubmissionID) {
+			w.finish(ctx, msg, src, createResultEvent(j, job.StatusInternalError))
+		}
+		return
+	}
+
+	if src.stage == stageCompile {
+		w.processCompileMessage(ctx, msg, src, j, payloadStr)
+		return
+	}
+
+	if !w.claim(ctx, msg, src, j.SubmissionID) {
+		return
+	}
+
+	jobCtx, done := w.startJob(ctx, msg, j)
+	defer done()
+
+	var res *job.ResultEvent
+	if context.Cause(jobCtx) == errCancelled {
+		res = createResultEvent(j, job.StatusCancelled)
+	} else {
+		res = w.judge(jobCtx, j, src.stage)
+	}
+
+	if context.Cause(jobCtx) == errCancelled {
+		res.Status = job.StatusCancelled
+	}
+
+	if !w.finish(ctx, msg, src, res) {
 		return
 	}
 
-	err := postgres.ClaimJob(db, j.SubmissionID, consumerId)
+	if src.stage == stageRun {
+		w.deleteBuildArtifact(msg, j.SubmissionID)
+	}
+}
+
+// deleteBuildArtifact drops the build of a submission that no stage is
+// going to run anymore.
+func (w *worker) deleteBuildArtifact(msg redis.XMessage, submissionID string) {
+	if err := postgres.DeleteBuildArtifact(w.db, submissionID); err != nil {
+		log.Printf("msg %v: deleting build artifact failed: %v", msg.ID, err)
+	}
+}
 
+// processCompileMessage is the compile stage: a successful build is stored
+// as an artifact and handed to the run stage, while compile errors, cached
+// results and cancellations finish the submission right here.
+func (w *worker) processCompileMessage(ctx context.Context, msg redis.XMessage, src source, j job.Job, payload string) {
+	processed, err := postgres.IsJobProcessed(w.db, j.SubmissionID)
 	if err != nil {
-		if err == postgres.ErrAlreadyClaimed {
-			rescueResult(ctx, rdb, db, j.SubmissionID, msg, streamName, groupName)
+		log.Printf("msg %v: processed check failed: %v — leaving in PEL", msg.ID, err)
+		return
+	}
+	if processed {
+		w.rescueResult(ctx, j.SubmissionID, msg, src.stream, src.group)
+		return
+	}
+
+	if err := postgres.ClaimCompile(w.db, j.SubmissionID, src.stream+"/"+msg.ID, w.consumerID); err != nil {
+		if err != postgres.ErrAlreadyClaimed {
+			log.Printf("msg %v: compile claim error: %v — leaving in PEL", msg.ID, err)
 			return
 		}
-		log.Printf("msg %v: claim error: %s leaving in PEL", msg.ID, err)
+		// The other copy is built and handed off on its own.
+		log.Printf("msg %v: submission %s is compiled from another message, acking", msg.ID, j.SubmissionID)
+		xack(ctx, w.rdb, src.stream, src.group, msg.ID)
 		return
 	}
 
+	jobCtx, done := w.startJob(ctx, msg, j)
+	defer done()
+
+	key := w.resultCacheKey(j)
+
+	var sb *executor.Sandbox
 	var res *job.ResultEvent
-	switch workerTier {
-	case "free":
-		res = processFreeJob(j)
-	case "premium":
-		res = processPremiumJob(j)
-	default:
-		log.Printf("msg %v: unknown worker tier %s, acking to discard", msg.ID, workerTier)
-		xack(ctx, rdb, streamName, groupName, msg.ID)
+	if context.Cause(jobCtx) != errCancelled {
+		if res = w.cachedResult(j, key); res == nil {
+			sb, res = w.build(jobCtx, j)
+			if res != nil && jobCtx.Err() == nil {
+				w.cacheResult(key, res)
+			}
+		}
+	}
+	if context.Cause(jobCtx) == errCancelled {
+		if sb != nil {
+			sb.Close()
+			sb = nil
+		}
+		res = createResultEvent(j, job.StatusCancelled)
+	}
+
+	if sb == nil {
+		// An earlier attempt may have stored a build before it failed.
+		if w.claim(ctx, msg, src, j.SubmissionID) && w.finish(ctx, msg, src, res) {
+			w.deleteBuildArtifact(msg, j.SubmissionID)
+		}
 		return
 	}
+	defer sb.Close()
 
-	if err := postgres.SaveJobResult(db, j.SubmissionID, res); err != nil {
-		log.Printf("msg %s: SaveJobResult failed: %v — leaving in PEL", msg.ID, err)
+	archive, err := sb.Archive()
+	if err != nil {
+		log.Printf("msg %v: archiving build failed: %v — leaving in PEL", msg.ID, err)
+		return
+	}
+	if err := postgres.SaveBuildArtifact(w.db, j.SubmissionID, archive); err != nil {
+		log.Printf("msg %v: SaveBuildArtifact failed: %v — leaving in PEL", msg.ID, err)
+		return
+	}
+
+	_, err = w.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream.RunStream(src.tier, src.language),
+		ID:     "*",
+		Values: runValues(msg, j.SubmissionID, payload),
+	}).Result()
+	if err != nil {
+		log.Printf("msg %v: handing off to run stage failed: %v — leaving in PEL", msg.ID, err)
 		return
 	}
 
-	streamErr := stream.PublishResult(ctx, rdb, res)
+	xack(ctx, w.rdb, src.stream, src.group, msg.ID)
+	log.Printf("msg %v: acked, submission %s compiled", msg.ID, j.SubmissionID)
+}
+
+// runValues builds the run-stage message of a compiled job. A payload the
+// relay passed by reference is handed on by reference too.
+func runValues(msg redis.XMessage, submissionID, payload string) map[string]interface{} {
+	if ref, ok := msg.Values[stream.PayloadRefField].(string); ok {
+		return map[string]interface{}{"submission_id": submissionID, stream.PayloadRefField: ref}
+	}
+	return map[string]interface{}{"submission_id": submissionID, stream.PayloadField: payload}
+}
+
+// claim reserves the submission for this worker. When another worker got
+// there first, its stored result is re-published instead.
+func (w *worker) claim(ctx context.Context, msg redis.XMessage, src source, submissionID string) bool {
+	err := postgres.ClaimJob(w.db, submissionID, w.consumerID)
+
+	if err != nil {
+		if err == postgres.ErrAlreadyClaimed {
+			w.rescueResult(ctx, submissionID, msg, src.stream, src.group)
+			return false
+		}
+		log.Printf("msg %v: claim error: %s leaving in PEL", msg.ID, err)
+		return false
+	}
+	return true
+}
+
+// startJob registers the job for cancellation and applies a cancellation
+// requested before it was picked up.
+func (w *worker) startJob(ctx context.Context, msg redis.XMessage, j job.Job) (context.Context, func()) {
+	jobCtx, done := w.running.start(ctx, j.SubmissionID)
+
+	if cancelled, err := cancellation.IsRequested(ctx, w.rdb, j.SubmissionID); err != nil {
+		log.Printf("msg %v: cancellation check failed: %v — judging anyway", msg.ID, err)
+	} else if cancelled {
+		w.running.cancel(j.SubmissionID)
+	}
+	return jobCtx, done
+}
+
+// finish stores and publishes a final result and acks the message. It
+// reports whether the message was acked.
+func (w *worker) finish(ctx context.Context, msg redis.XMessage, src source, res *job.ResultEvent) bool {
+	if err := postgres.SaveJobResult(w.db, res.SubmissionID, res); err != nil {
+		log.Printf("msg %s: SaveJobResult failed: %v — leaving in PEL", msg.ID, err)
+		return false
+	}
+
+	streamErr := stream.PublishResult(ctx, w.rdb, res)
 	if streamErr != nil {
 		log.Printf("msg %s: stream publish failed: %v — attempting direct DB write", msg.ID, streamErr)
-		if dbErr := postgres.InsertResultEvent(db, res); dbErr != nil {
+		if dbErr := postgres.InsertResultEvent(w.db, res); dbErr != nil {
 			log.Printf("msg %s: direct DB write also failed: %v — leaving in PEL", msg.ID, dbErr)
-			return
+			return false
+		}
+		if dbErr := postgres.MarkOutboxEntryCompleted(w.db, res.SubmissionID); dbErr != nil {
+			log.Printf("msg %s: MarkOutboxEntryCompleted failed: %v — leaving in PEL", msg.ID, dbErr)
+			return false
 		}
 	}
 
-	xack(ctx, rdb, streamName, groupName, msg.ID)
-	log.Printf("msg %v: acked, submission %s done", msg.ID, j.SubmissionID)
+	xack(ctx, w.rdb, src.stream, src.group, msg.ID)
+	log.Printf("msg %v: acked, submission %s done", msg.ID, res.SubmissionID)
+	return true
 }
 
-func rescueResult(ctx context.Context, rdb *redis.Client, db *sqlx.DB, submissionID string, msg redis.XMessage, streamName, groupName string) {
-	res, err := postgres.GetJobResult(db, submissionID)
+func (w *worker) rescueResult(ctx context.Context, submissionID string, msg redis.XMessage, streamName, groupName string) {
+	res, err := postgres.GetJobResult(w.db, submissionID)
 	if err != nil {
 		log.Printf("msg %s: GetJobResult failed: %v — leaving in PEL", msg.ID, err)
 		return
@@ -192,20 +783,28 @@ func rescueResult(ctx context.Context, rdb *redis.Client, db *sqlx.DB, submissio
 
 	if res == nil {
 		log.Printf("msg %s: submission %s claimed but result not yet stored — discarding", msg.ID, submissionID)
-		xack(ctx, rdb, streamName, groupName, msg.ID)
+		xack(ctx, w.rdb, streamName, groupName, msg.ID)
+		// The claimer loaded the build right after claiming, if it
+		// is alive at all.
+		w.deleteBuildArtifact(msg, submissionID)
 		return
 	}
 
-	if err := postgres.InsertResultEvent(db, res); err != nil {
+	if err := postgres.InsertResultEvent(w.db, res); err != nil {
 		log.Printf("msg %s: rescue InsertResultEvent failed: %v — leaving in PEL", msg.ID, err)
 		return
 	}
+	if err := postgres.MarkOutboxEntryCompleted(w.db, submissionID); err != nil {
+		log.Printf("msg %s: rescue MarkOutboxEntryCompleted failed: %v — leaving in PEL", msg.ID, err)
+		return
+	}
 
-	xack(ctx, rdb, streamName, groupName, msg.ID)
+	xack(ctx, w.rdb, streamName, groupName, msg.ID)
+	w.deleteBuildArtifact(msg, submissionID)
 	log.Printf("msg %s: rescued, submission %s done", msg.ID, submissionID)
 }
 
-func reaper(ctx context.Context, rdb *redis.Client, db *sqlx.DB, streamName, groupName, consumerID, workerTier string) {
+func (w *worker) reaper(ctx context.Context, src source) {
 	ticker := time.NewTicker(reaperDuration)
 	defer ticker.Stop()
 
@@ -215,10 +814,10 @@ func reaper(ctx context.Context, rdb *redis.Client, db *sqlx.DB, streamName, gro
 			log.Println("context cancellation received, exiting reaper gracefully")
 			return
 		case <-ticker.C:
-			claimed, _, err := rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
-				Stream:   streamName,
-				Group:    groupName,
-				Consumer: consumerID,
+			claimed, _, err := w.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   src.stream,
+				Group:    src.group,
+				Consumer: w.consumerID,
 				MinIdle:  staleDuration,
 				Start:    "0-0",
 				Count:    10,
@@ -233,7 +832,7 @@ func reaper(ctx context.Context, rdb *redis.Client, db *sqlx.DB, streamName, gro
 			}
 
 			for _, msg := range claimed {
-				processMessage(ctx, rdb, db, msg, streamName, groupName, consumerID, workerTier)
+				w.processMessage(ctx, msg, src)
 			}
 		}
 	}
@@ -245,28 +844,26 @@ func xack(ctx context.Context, rdb *redis.Client, streamName, groupName, id stri
 	}
 }
 
-func processFreeJob(j job.Job) *job.ResultEvent {
-	dur := time.Duration(5+rand.IntN(3)) * time.Second
-	time.Sleep(dur)
-	return createResultEvent(j, int(dur.Milliseconds()))
-}
-
-func processPremiumJob(j job.Job) *job.ResultEvent {
-	dur := time.Duration(3+rand.IntN(2)) * time.Second
-	time.Sleep(dur)
-	return createResultEvent(j, int(dur.Milliseconds()))
-}
-
-func createResultEvent(j job.Job, durationMs int) *job.ResultEvent {
+func createResultEvent(j job.Job, status string) *job.ResultEvent {
 	return &job.ResultEvent{
 		SubmissionID: j.SubmissionID,
 		UserID:       j.UserID,
 		Language:     j.Language,
 		Tier:         j.Tier,
-		ExecutionMs:  durationMs,
-		Status:       "ACCEPTED",
+		Status:       status,
 		CompletedAt:  time.Now(),
+		Promoted:     j.Promoted,
+	}
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
 
 func getEnv(key, def string) string {
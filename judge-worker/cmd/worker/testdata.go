@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"judge-worker/internal/postgres"
+	"judge-worker/internal/problem"
+	"judge-worker/internal/testdata"
+	"log"
+	"time"
+)
+
+// hotWindow is how far back prefetching looks for popular problems.
+const hotWindow = time.Hour
+
+// loadProblem loads a problem, taking its tests from the local test data
+// cache when this worker has judged or prefetched its current version. It
+// returns nil if the problem does not exist.
+func (w *worker) loadProblem(problemID string) (*problem.Problem, error) {
+	if w.testdata == nil {
+		return postgres.GetProblem(w.db, problemID)
+	}
+
+	p, err := postgres.GetProblemInfo(w.db, problemID)
+	if err != nil || p == nil {
+		return nil, err
+	}
+
+	key := testdata.Key(p.ID, p.Version)
+	if tests, ok := w.testdata.Get(key); ok {
+		p.Tests = tests
+		return p, nil
+	}
+
+	tests, current, err := postgres.GetProblemTests(w.db, p.ID, p.Version)
+	if err != nil {
+		return nil, err
+	}
+	if !current {
+		// The problem changed in between; its new version is cached on
+		// the next load.
+		return postgres.GetProblem(w.db, problemID)
+	}
+	if err := w.testdata.Put(key, tests); err != nil {
+		log.Printf("problem %s: caching tests failed: %v", p.ID, err)
+	}
+	p.Tests = tests
+	return p, nil
+}
+
+// prefetchTests keeps the tests of the most submitted problems cached, so
+// the first job after a new version lands does not wait for them.
+func (w *worker) prefetchTests(ctx context.Context, count int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		hot, err := postgres.HotProblems(w.db, hotWindow, count)
+		if err != nil && ctx.Err() == nil {
+			log.Println("hot problems lookup failed:", err)
+		}
+
+		for _, pv := range hot {
+			if ctx.Err() != nil {
+				return
+			}
+			key := testdata.Key(pv.ProblemID, pv.Version)
+			if w.testdata.Contains(key) {
+				continue
+			}
+
+			tests, current, err := postgres.GetProblemTests(w.db, pv.ProblemID, pv.Version)
+			if err != nil {
+				log.Printf("problem %s: prefetching tests failed: %v", pv.ProblemID, err)
+				continue
+			}
+			if !current {
+				continue
+			}
+			if err := w.testdata.Put(key, tests); err != nil {
+				log.Printf("problem %s: caching tests failed: %v", pv.ProblemID, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package blob
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// Hash is the content address of data: its hex-encoded SHA-256.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Cache keeps recently used blobs in memory, evicting the least recently
+// used ones once their total size exceeds the limit. Blobs are immutable,
+// so entries never go stale.
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type entry struct {
+	hash string
+	data []byte
+}
+
+func NewCache(maxBytes int) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *Cache) Get(hash string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[hash]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*entry).data, true
+}
+
+// Add caches a blob. Blobs larger than the whole cache are not kept.
+func (c *Cache) Add(hash string, data []byte) {
+	if len(data) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[hash]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[hash] = c.order.PushFront(&entry{hash: hash, data: data})
+	c.size += len(data)
+
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		e := oldest.Value.(*entry)
+		c.order.Remove(oldest)
+		delete(c.entries, e.hash)
+		c.size -= len(e.data)
+	}
+}
@@ -0,0 +1,65 @@
+package cancellation
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	channel   = "submission-cancellations"
+	markerTTL = 24 * time.Hour
+)
+
+func markerKey(submissionID string) string {
+	return "cancel:" + submissionID
+}
+
+// Request leaves a cancellation marker for workers that have not picked the
+// submission up yet and notifies workers that may be running it right now.
+func Request(ctx context.Context, rdb *redis.Client, submissionID string) error {
+	if err := rdb.Set(ctx, markerKey(submissionID), 1, markerTTL).Err(); err != nil {
+		return err
+	}
+	return rdb.Publish(ctx, channel, submissionID).Err()
+}
+
+func IsRequested(ctx context.Context, rdb *redis.Client, submissionID string) (bool, error) {
+	n, err := rdb.Exists(ctx, markerKey(submissionID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Subscribe streams the IDs of submissions cancelled while the subscription
+// is open. The channel is closed when ctx is done.
+func Subscribe(ctx context.Context, rdb *redis.Client) <-chan string {
+	ids := make(chan string)
+	sub := rdb.Subscribe(ctx, channel)
+
+	go func() {
+		defer close(ids)
+		defer sub.Close()
+
+		msgs := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				select {
+				case ids <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ids
+}
@@ -0,0 +1,122 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Verdict is a checker's judgement of one test. Score is the share of the
+// test's credit earned, from 0 to 1.
+type Verdict struct {
+	Accepted bool
+	Score    float64
+}
+
+type Checker interface {
+	Check(ctx context.Context, input, expected, actual string) (Verdict, error)
+}
+
+// Builtin names, as stored in problems.checker. The float checker takes its
+// tolerance after a colon, as in "float:1e-6".
+const (
+	Exact          = "exact"
+	Tokens         = "tokens"
+	Float          = "float"
+	UnorderedLines = "unordered_lines"
+	Custom         = "custom"
+)
+
+const defaultFloatTolerance = 1e-6
+
+type funcChecker func(expected, actual string) bool
+
+func (f funcChecker) Check(_ context.Context, _, expected, actual string) (Verdict, error) {
+	if f(expected, actual) {
+		return Verdict{Accepted: true, Score: 1}, nil
+	}
+	return Verdict{}, nil
+}
+
+// Builtin returns the built-in checker described by spec. An empty spec is
+// the exact checker.
+func Builtin(spec string) (Checker, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	switch name {
+	case "", Exact:
+		return funcChecker(exactMatch), nil
+	case Tokens:
+		return funcChecker(tokensMatch), nil
+	case UnorderedLines:
+		return funcChecker(unorderedLinesMatch), nil
+	case Float:
+		tolerance := defaultFloatTolerance
+		if arg != "" {
+			t, err := strconv.ParseFloat(arg, 64)
+			if err != nil || t < 0 {
+				return nil, fmt.Errorf("checker %q: invalid tolerance", spec)
+			}
+			tolerance = t
+		}
+		return funcChecker(func(expected, actual string) bool {
+			return floatsMatch(expected, actual, tolerance)
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown checker %q", spec)
+}
+
+// exactMatch compares outputs line by line, ignoring trailing whitespace on
+// each line and trailing blank lines.
+func exactMatch(expected, actual string) bool {
+	return slices.Equal(lines(expected), lines(actual))
+}
+
+func tokensMatch(expected, actual string) bool {
+	return slices.Equal(strings.Fields(expected), strings.Fields(actual))
+}
+
+func unorderedLinesMatch(expected, actual string) bool {
+	want, got := lines(expected), lines(actual)
+	slices.Sort(want)
+	slices.Sort(got)
+	return slices.Equal(want, got)
+}
+
+// floatsMatch compares token by token. Numeric tokens may differ by the
+// tolerance, absolute or relative to the expected value; anything else must
+// match exactly.
+func floatsMatch(expected, actual string, tolerance float64) bool {
+	want, got := strings.Fields(expected), strings.Fields(actual)
+	if len(want) != len(got) {
+		return false
+	}
+
+	for i := range want {
+		if want[i] == got[i] {
+			continue
+		}
+		w, errW := strconv.ParseFloat(want[i], 64)
+		g, errG := strconv.ParseFloat(got[i], 64)
+		if errW != nil || errG != nil || math.IsNaN(g) {
+			return false
+		}
+		if math.Abs(w-g) > tolerance*math.Max(1, math.Abs(w)) {
+			return false
+		}
+	}
+	return true
+}
+
+func lines(s string) []string {
+	ls := strings.Split(s, "\n")
+	for i, l := range ls {
+		ls[i] = strings.TrimRight(l, " \t\r")
+	}
+	for len(ls) > 0 && ls[len(ls)-1] == "" {
+		ls = ls[:len(ls)-1]
+	}
+	return ls
+}
@@ -0,0 +1,71 @@
+package checker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBuiltin(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		expected string
+		actual   string
+		want     bool
+	}{
+		{"empty spec is exact", "", "1 2\n", "1 2\n", true},
+		{"exact ignores trailing whitespace", Exact, "1 2\n3\n", "1 2  \r\n3\t\n\n\n", true},
+		{"exact keeps leading whitespace", Exact, "1 2\n", " 1 2\n", false},
+		{"exact keeps inner spacing", Exact, "1 2\n", "1  2\n", false},
+		{"exact keeps line breaks", Exact, "1 2\n", "1\n2\n", false},
+		{"exact rejects missing lines", Exact, "1\n2\n", "1\n", false},
+
+		{"tokens ignores layout", Tokens, "1 2\n3\n", "  1\n2   3", true},
+		{"tokens compares values", Tokens, "1 2 3", "1 2 4", false},
+		{"tokens rejects extra tokens", Tokens, "1 2", "1 2 3", false},
+
+		{"unordered lines in any order", UnorderedLines, "a\nb\nc\n", "c\na\nb", true},
+		{"unordered lines keeps duplicates", UnorderedLines, "a\na\nb\n", "a\nb\nb\n", false},
+		{"unordered lines ignores trailing blanks", UnorderedLines, "a\nb\n", "b  \na\n\n", true},
+
+		{"float default tolerance", Float, "0.3333333", "0.33333331", true},
+		{"float outside default tolerance", Float, "0.3333", "0.3334", false},
+		{"float custom tolerance", "float:1e-3", "0.3333", "0.3334", true},
+		{"float relative tolerance", "float:1e-6", "1000000", "1000000.5", true},
+		{"float non-numeric tokens match exactly", Float, "YES 1.0", "YES 1.0000001", true},
+		{"float non-numeric mismatch", Float, "YES 1.0", "NO 1.0", false},
+		{"float rejects NaN", Float, "1.0", "NaN", false},
+		{"float token count", Float, "1.0 2.0", "1.0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Builtin(tt.spec)
+			if err != nil {
+				t.Fatalf("Builtin(%q): %v", tt.spec, err)
+			}
+			v, err := c.Check(context.Background(), "", tt.expected, tt.actual)
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if v.Accepted != tt.want {
+				t.Errorf("Accepted = %v, want %v", v.Accepted, tt.want)
+			}
+			wantScore := 0.0
+			if tt.want {
+				wantScore = 1
+			}
+			if v.Score != wantScore {
+				t.Errorf("Score = %v, want %v", v.Score, wantScore)
+			}
+		})
+	}
+}
+
+func TestBuiltinInvalidSpec(t *testing.T) {
+	for _, spec := range []string{"float:x", "float:-1", "regex", Custom} {
+		if _, err := Builtin(spec); err == nil {
+			t.Errorf("Builtin(%q) succeeded, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package checker
+
+import (
+	"strings"
+
+	"judge-worker/internal/job"
+)
+
+// diffContext is how many bytes of a line are shown before the first
+// differing column; snippets are at most diffSnippet bytes long.
+const (
+	diffContext = 16
+	diffSnippet = 64
+)
+
+// FirstDifference finds the first line where actual departs from expected,
+// ignoring trailing whitespace the way the exact checker does. It returns
+// nil when the outputs only differ in ways checkers may tolerate.
+func FirstDifference(expected, actual string) *job.Diff {
+	exp, act := lines(expected), lines(actual)
+
+	for i := 0; i < max(len(exp), len(act)); i++ {
+		var e, a string
+		if i < len(exp) {
+			e = exp[i]
+		}
+		if i < len(act) {
+			a = act[i]
+		}
+		if e == a && i < len(exp) && i < len(act) {
+			continue
+		}
+
+		col := 0
+		for col < len(e) && col < len(a) && e[col] == a[col] {
+			col++
+		}
+		return &job.Diff{
+			Line:     i + 1,
+			Column:   col + 1,
+			Expected: snippet(e, col),
+			Actual:   snippet(a, col),
+		}
+	}
+	return nil
+}
+
+// Diffable reports whether FirstDifference explains the failures of the
+// checker spec describes. Only the exact checker compares outputs line by
+// line as it does; under any other, the first differing line may be one the
+// checker accepted.
+func Diffable(spec string) bool {
+	name, _, _ := strings.Cut(spec, ":")
+	return name == "" || name == Exact
+}
+
+// snippet cuts the part of line around col that a user needs to spot the
+// difference.
+func snippet(line string, col int) string {
+	start := max(0, col-diffContext)
+	end := min(len(line), start+diffSnippet)
+	if start >= end {
+		return ""
+	}
+	return strings.ToValidUTF8(line[start:end], "")
+}
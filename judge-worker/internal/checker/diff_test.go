@@ -0,0 +1,66 @@
+package checker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"judge-worker/internal/job"
+)
+
+func TestFirstDifference(t *testing.T) {
+	long := strings.Repeat("a", 100)
+
+	tests := []struct {
+		name     string
+		expected string
+		actual   string
+		want     *job.Diff
+	}{
+		{"identical", "1 2\n3\n", "1 2\n3\n", nil},
+		{"trailing whitespace ignored", "1 2\n3\n", "1 2 \r\n3\t\n\n", nil},
+		{"empty outputs", "", "", nil},
+		{"first line differs", "1 2\n", "1 3\n", &job.Diff{Line: 1, Column: 3, Expected: "1 2", Actual: "1 3"}},
+		{"later line differs", "a\nb\nc\n", "a\nb\nx\n", &job.Diff{Line: 3, Column: 1, Expected: "c", Actual: "x"}},
+		{"actual is a prefix", "abc\n", "ab\n", &job.Diff{Line: 1, Column: 3, Expected: "abc", Actual: "ab"}},
+		{"missing line", "a\nb\n", "a\n", &job.Diff{Line: 2, Column: 1, Expected: "b", Actual: ""}},
+		{"extra line", "a\n", "a\nb\n", &job.Diff{Line: 2, Column: 1, Expected: "", Actual: "b"}},
+		{"extra blank line in between", "a\nb\n", "a\n\nb\n", &job.Diff{Line: 2, Column: 1, Expected: "b", Actual: ""}},
+		{
+			"long line is cut around the column",
+			long + "b" + long, long + "c" + long,
+			&job.Diff{Line: 1, Column: 101, Expected: long[:16] + "b" + long[:47], Actual: long[:16] + "c" + long[:47]},
+		},
+		{"invalid UTF-8 is dropped", "ab\xe2\x82\n", "ab\xe2\x83\n", &job.Diff{Line: 1, Column: 4, Expected: "ab", Actual: "ab"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FirstDifference(tt.expected, tt.actual)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FirstDifference = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffable(t *testing.T) {
+	tests := []struct {
+		spec string
+		want bool
+	}{
+		{"", true},
+		{Exact, true},
+		{Tokens, false},
+		{Float, false},
+		{"float:1e-3", false},
+		{UnorderedLines, false},
+		{Custom, false},
+	}
+
+	for _, tt := range tests {
+		if got := Diffable(tt.spec); got != tt.want {
+			t.Errorf("Diffable(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"judge-worker/internal/executor"
+	"judge-worker/internal/language"
+	"judge-worker/internal/limits"
+)
+
+const (
+	programCompileTimeout = 30 * time.Second
+	programRunTimeout     = 10 * time.Second
+)
+
+// Program is a problem's own checker. It is started as
+//
+//	checker <input> <expected> <actual>
+//
+// and accepts by exiting 0 or rejects by exiting 1. The first line of its
+// stdout may hold a score between 0 and 1; without one an accepted test
+// scores 1 and a rejected one 0. Any other exit is a checker failure.
+type Program struct {
+	sb *executor.Sandbox
+}
+
+// NewProgram builds the checker in its own sandbox. Close releases it.
+func NewProgram(ctx context.Context, ex *executor.Executor, lang, source string) (*Program, error) {
+	recipe, ok := language.Lookup(lang)
+	if !ok {
+		return nil, fmt.Errorf("checker: unsupported language %q", lang)
+	}
+
+	sb, err := ex.NewSandbox(recipe, source)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := sb.Compile(ctx, programCompileTimeout)
+	if err != nil {
+		sb.Close()
+		return nil, err
+	}
+	if !out.OK() {
+		sb.Close()
+		return nil, fmt.Errorf("checker: compilation failed: %s", out.Stderr)
+	}
+	return &Program{sb: sb}, nil
+}
+
+func (p *Program) Close() error {
+	return p.sb.Close()
+}
+
+func (p *Program) Check(ctx context.Context, input, expected, actual string) (Verdict, error) {
+	// Checks of tests running side by side share the sandbox, so every
+	// check writes files of its own.
+	files := []struct{ pattern, data string }{
+		{"checker_input-*.txt", input},
+		{"checker_expected-*.txt", expected},
+		{"checker_actual-*.txt", actual},
+	}
+	args := make([]string, 0, len(files))
+	defer func() {
+		for _, name := range args {
+			p.sb.Remove(name)
+		}
+	}()
+	for _, f := range files {
+		name, err := p.sb.WriteTemp(f.pattern, []byte(f.data))
+		if err != nil {
+			return Verdict{}, err
+		}
+		args = append(args, name)
+	}
+
+	out, err := p.sb.RunArgs(ctx, args, nil, limits.Wall(programRunTimeout))
+	if err != nil {
+		return Verdict{}, err
+	}
+	if out.TimedOut {
+		return Verdict{}, fmt.Errorf("checker: timed out")
+	}
+
+	var v Verdict
+	switch out.ExitCode {
+	case 0:
+		v = Verdict{Accepted: true, Score: 1}
+	case 1:
+		v = Verdict{Accepted: false, Score: 0}
+	default:
+		return Verdict{}, fmt.Errorf("checker: exited with %d: %s", out.ExitCode, out.Stderr)
+	}
+
+	first, _, _ := strings.Cut(string(out.Stdout), "\n")
+	if first = strings.TrimSpace(first); first != "" {
+		score, err := strconv.ParseFloat(first, 64)
+		if err != nil || score < 0 || score > 1 {
+			return Verdict{}, fmt.Errorf("checker: invalid score %q", first)
+		}
+		v.Score = score
+	}
+	return v, nil
+}
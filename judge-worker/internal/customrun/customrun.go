@@ -0,0 +1,131 @@
+package customrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Custom runs execute a user's code on their own input. They never touch
+// Postgres: requests go straight onto their own stream, which dedicated
+// workers read without acknowledgements, and results only live in redis
+// for resultTTL.
+const (
+	Stream = "custom-input-stream"
+	Group  = "workers-custom-input"
+
+	// streamMaxLen bounds the stream; runs nobody picked up by then are
+	// dropped and expire as QUEUED.
+	streamMaxLen = 10000
+	resultTTL    = 5 * time.Minute
+)
+
+const (
+	StatusQueued = "QUEUED"
+	StatusOK     = "OK"
+)
+
+type Request struct {
+	RunID    string `json:"run_id"`
+	UserID   string `json:"user_id"`
+	Language string `json:"language"`
+	Source   string `json:"source"`
+	Input    string `json:"input"`
+}
+
+type Result struct {
+	RunID       string    `json:"run_id"`
+	Status      string    `json:"status"`
+	Stdout      string    `json:"stdout"`
+	Stderr      string    `json:"stderr"`
+	Truncated   bool      `json:"truncated,omitempty"`
+	ExitCode    int       `json:"exit_code"`
+	Signal      int       `json:"signal,omitempty"`
+	CPUMs       int       `json:"cpu_ms"`
+	WallMs      int       `json:"wall_ms"`
+	PeakRSSKB   int64     `json:"peak_rss_kb"`
+	CompletedAt time.Time `json:"completed_at,omitempty"`
+}
+
+func resultKey(runID string) string {
+	return "custom-run:" + runID
+}
+
+// Enqueue records the run as queued and hands it to the workers.
+func Enqueue(ctx context.Context, rdb *redis.Client, req Request) error {
+	if err := SaveResult(ctx, rdb, &Result{RunID: req.RunID, Status: StatusQueued}); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: Stream,
+		MaxLen: streamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"payload": payload},
+	}).Err()
+}
+
+// Next waits up to block for the next run request. It returns nil when none
+// arrived. Entries are not acknowledged: a run lost with its worker simply
+// expires as QUEUED.
+func Next(ctx context.Context, rdb *redis.Client, consumer string, block time.Duration) (*Request, error) {
+	streams, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    Group,
+		Consumer: consumer,
+		Streams:  []string{Stream, ">"},
+		Count:    1,
+		Block:    block,
+		NoAck:    true,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range streams {
+		for _, msg := range s.Messages {
+			payload, _ := msg.Values["payload"].(string)
+			var req Request
+			if err := json.Unmarshal([]byte(payload), &req); err != nil {
+				return nil, err
+			}
+			return &req, nil
+		}
+	}
+	return nil, nil
+}
+
+func SaveResult(ctx context.Context, rdb *redis.Client, res *Result) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, resultKey(res.RunID), data, resultTTL).Err()
+}
+
+// GetResult returns the run's result, or nil once it has expired or if the
+// run never existed.
+func GetResult(ctx context.Context, rdb *redis.Client, runID string) (*Result, error) {
+	data, err := rdb.Get(ctx, resultKey(runID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res Result
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,116 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// cpuSet is the kernel's cpu_set_t, large enough for 1024 CPUs.
+type cpuSet [16]uint64
+
+type cpuKey struct{}
+
+// WithCPU makes the programs started under ctx run on the given CPU only,
+// so tests running side by side never compete for a core.
+func WithCPU(ctx context.Context, cpu int) context.Context {
+	return context.WithValue(ctx, cpuKey{}, cpu)
+}
+
+func cpuFrom(ctx context.Context) (int, bool) {
+	cpu, ok := ctx.Value(cpuKey{}).(int)
+	return cpu, ok
+}
+
+// AvailableCPUs lists the CPUs this process may run on, in order.
+func AvailableCPUs() ([]int, error) {
+	set, err := getAffinity(0)
+	if err != nil {
+		return nil, err
+	}
+
+	var cpus []int
+	for i := 0; i < len(set)*64; i++ {
+		if set[i/64]&(1<<(i%64)) != 0 {
+			cpus = append(cpus, i)
+		}
+	}
+	return cpus, nil
+}
+
+// startOn starts cmd, on the CPU ctx asks for if any. A child inherits the
+// affinity of the thread that forks it, so that thread is pinned while it
+// starts the command.
+func startOn(ctx context.Context, cmd *exec.Cmd) error {
+	cpu, ok := cpuFrom(ctx)
+	if !ok {
+		return cmd.Start()
+	}
+
+	runtime.LockOSThread()
+	old, err := getAffinity(0)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	if err := setAffinity(0, single(cpu)); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+
+	err = cmd.Start()
+
+	// A thread that cannot be restored stays locked, so the runtime
+	// retires it with this goroutine instead of reusing it.
+	if setAffinity(0, &old) == nil {
+		runtime.UnlockOSThread()
+	}
+	return err
+}
+
+// pin moves every thread of a running process to cpu. Threads it creates
+// later inherit the affinity.
+func pin(pid, cpu int) error {
+	tasks, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := setAffinity(tid, single(cpu)); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+func single(cpu int) *cpuSet {
+	var set cpuSet
+	set[cpu/64] |= 1 << (cpu % 64)
+	return &set
+}
+
+func getAffinity(tid int) (cpuSet, error) {
+	var set cpuSet
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, uintptr(tid), unsafe.Sizeof(set), uintptr(unsafe.Pointer(&set)))
+	if errno != 0 {
+		return set, errno
+	}
+	return set, nil
+}
+
+func setAffinity(tid int, set *cpuSet) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), unsafe.Sizeof(*set), uintptr(unsafe.Pointer(set)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"judge-worker/internal/limits"
+)
+
+// watchInterval is how often a running program's usage is sampled.
+const watchInterval = 10 * time.Millisecond
+
+// exceeded tells which limit a run was stopped for.
+type exceeded int
+
+const (
+	withinLimits exceeded = iota
+	cpuExceeded
+	memoryExceeded
+)
+
+// usage is what the watchdog saw of a run: the limit it was stopped for,
+// and the most CPU time and memory its process group used at any sample.
+type usage struct {
+	hit   exceeded
+	cpu   time.Duration
+	rssKB int64
+}
+
+// watch samples a running process group and kills it as soon as it uses
+// more CPU time or memory than lim allows. baseCPU is what the group had
+// used before the run began. The returned func stops watching and reports
+// what it saw.
+func watch(pid int, lim limits.Limits, baseCPU time.Duration) func() usage {
+	if lim.TimeMs <= 0 && lim.MemoryKB <= 0 {
+		return func() usage { return usage{} }
+	}
+
+	done := make(chan struct{})
+	var u usage
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			cpu, rssKB, err := groupStat(pid)
+			if err != nil {
+				// Gone already; Wait reports how it ended.
+				return
+			}
+			u.cpu = max(u.cpu, cpu-baseCPU)
+			u.rssKB = max(u.rssKB, rssKB)
+			switch {
+			case lim.MemoryKB > 0 && rssKB > lim.MemoryKB:
+				u.hit = memoryExceeded
+			case lim.TimeMs > 0 && cpu-baseCPU > lim.Time():
+				u.hit = cpuExceeded
+			default:
+				continue
+			}
+			syscall.Kill(-pid, syscall.SIGKILL)
+			return
+		}
+	}()
+
+	return func() usage {
+		close(done)
+		wg.Wait()
+		return u
+	}
+}
+
+// enforce judges a finished run against lim. The leader's rusage misses
+// members of its group it never waited for, so the usage the watchdog saw
+// counts where it is higher. The watchdog samples, so a run may also slip
+// past a limit between samples; rusage catches that.
+func enforce(out *Outcome, lim limits.Limits, u usage) {
+	out.CPUTime = max(out.CPUTime, u.cpu)
+	out.PeakMemoryKB = max(out.PeakMemoryKB, u.rssKB)
+
+	switch {
+	case u.hit == memoryExceeded || (lim.MemoryKB > 0 && out.PeakMemoryKB > lim.MemoryKB):
+		out.MemoryExceeded = true
+		out.ExitCode = -1
+	case u.hit == cpuExceeded || (lim.TimeMs > 0 && out.CPUTime > lim.Time()):
+		out.TimedOut = true
+		out.ExitCode = -1
+	}
+}
@@ -0,0 +1,320 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"judge-worker/internal/language"
+	"judge-worker/internal/limits"
+)
+
+// maxOutputBytes caps how much of each output stream is kept in memory.
+// Anything past it is read and discarded so the process never blocks.
+const maxOutputBytes = 16 << 20
+
+type Executor struct {
+	root  string
+	pools map[string]*Pool
+}
+
+func New(root string) (*Executor, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &Executor{root: root}, nil
+}
+
+// Sandbox is a scratch directory holding one submission and whatever its
+// build produces. It must be closed to remove the directory.
+type Sandbox struct {
+	Dir    string
+	recipe language.Recipe
+	pool   *Pool
+
+	logMu sync.Mutex
+	log   bytes.Buffer
+}
+
+// Outcome is how a run went. TimedOut is set when it ran out of CPU or
+// wall-clock time.
+type Outcome struct {
+	ExitCode     int
+	Signal       int
+	TimedOut     bool
+	Stdout       []byte
+	Stderr       []byte
+	WallTime     time.Duration
+	CPUTime      time.Duration
+	PeakMemoryKB int64
+
+	// MemoryExceeded is set when the run used more memory than allowed.
+	MemoryExceeded bool
+}
+
+func (o *Outcome) OK() bool {
+	return !o.TimedOut && !o.MemoryExceeded && o.ExitCode == 0
+}
+
+func (e *Executor) NewSandbox(recipe language.Recipe, source string) (*Sandbox, error) {
+	dir, err := os.MkdirTemp(e.root, "sandbox-")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, recipe.SourceFile), []byte(source), 0o644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return e.sandbox(dir, recipe), nil
+}
+
+// NewProjectSandbox lays out a multi-file submission. Paths are relative to
+// the sandbox; any that could point outside of it are refused.
+func (e *Executor) NewProjectSandbox(recipe language.Recipe, files map[string]string) (*Sandbox, error) {
+	dir, err := os.MkdirTemp(e.root, "sandbox-")
+	if err != nil {
+		return nil, err
+	}
+
+	for name, data := range files {
+		if !fs.ValidPath(name) || name == "." {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("file %q escapes the sandbox", name)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+	return e.sandbox(dir, recipe), nil
+}
+
+func (e *Executor) sandbox(dir string, recipe language.Recipe) *Sandbox {
+	return &Sandbox{Dir: dir, recipe: recipe, pool: e.pools[recipe.Name]}
+}
+
+func (s *Sandbox) Recipe() language.Recipe {
+	return s.recipe
+}
+
+// Sanitize makes tool output safe to show to users: paths into the sandbox
+// become relative to it, so messages do not reveal the worker's layout, and
+// invalid UTF-8 is dropped.
+func (s *Sandbox) Sanitize(b []byte) string {
+	msg := strings.ReplaceAll(string(b), s.Dir+"/", "")
+	msg = strings.ReplaceAll(msg, s.Dir, ".")
+	return strings.ToValidUTF8(msg, "")
+}
+
+// Log returns one line per command the sandbox ran: what it was, how it
+// exited and what it used.
+func (s *Sandbox) Log() []byte {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	return bytes.Clone(s.log.Bytes())
+}
+
+func (s *Sandbox) logf(format string, args ...interface{}) {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	fmt.Fprintf(&s.log, "%s "+format+"\n", append([]interface{}{time.Now().UTC().Format(time.RFC3339Nano)}, args...)...)
+}
+
+func (s *Sandbox) logOutcome(argv []string, out *Outcome, err error) {
+	s.logf("%q exit=%d signal=%d timed_out=%t memory_exceeded=%t cpu=%s wall=%s peak_rss=%dKB stdout=%dB stderr=%dB err=%v",
+		argv, out.ExitCode, out.Signal, out.TimedOut, out.MemoryExceeded, out.CPUTime, out.WallTime, out.PeakMemoryKB,
+		len(out.Stdout), len(out.Stderr), err)
+}
+
+func (s *Sandbox) Close() error {
+	return os.RemoveAll(s.Dir)
+}
+
+// Compile runs the recipe's build step. Interpreted languages succeed
+// immediately with an empty outcome.
+func (s *Sandbox) Compile(ctx context.Context, timeout time.Duration) (*Outcome, error) {
+	if !s.recipe.Compiled() {
+		return &Outcome{}, nil
+	}
+	return s.exec(ctx, s.recipe.Compile, nil, limits.Wall(timeout))
+}
+
+// Run runs the program on stdin and stops it once it exceeds lim.
+func (s *Sandbox) Run(ctx context.Context, stdin []byte, lim limits.Limits) (*Outcome, error) {
+	return s.run(ctx, s.recipe.Run, stdin, lim)
+}
+
+// RunArgs runs the program with extra command line arguments.
+func (s *Sandbox) RunArgs(ctx context.Context, args []string, stdin []byte, lim limits.Limits) (*Outcome, error) {
+	argv := append(append([]string{}, s.recipe.Run...), args...)
+	return s.run(ctx, argv, stdin, lim)
+}
+
+// run starts the program on a warm process of the language's pool when one
+// is ready, and cold otherwise.
+func (s *Sandbox) run(ctx context.Context, argv []string, stdin []byte, lim limits.Limits) (*Outcome, error) {
+	if s.pool != nil {
+		if wp := s.pool.take(); wp != nil {
+			return s.execWarm(ctx, wp, argv, stdin, lim)
+		}
+	}
+	return s.exec(ctx, argv, stdin, lim)
+}
+
+// WriteFile places a file next to the program inside the sandbox.
+func (s *Sandbox) WriteFile(name string, data []byte) error {
+	return os.WriteFile(filepath.Join(s.Dir, name), data, 0o644)
+}
+
+// WriteTemp places a file with a unique name built from pattern, as in
+// os.CreateTemp, next to the program and returns its name. Runs that share
+// the sandbox use it to keep their files apart.
+func (s *Sandbox) WriteTemp(pattern string, data []byte) (string, error) {
+	f, err := os.CreateTemp(s.Dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return filepath.Base(f.Name()), nil
+}
+
+// Remove deletes a file next to the program.
+func (s *Sandbox) Remove(name string) error {
+	return os.Remove(filepath.Join(s.Dir, name))
+}
+
+func (s *Sandbox) exec(ctx context.Context, argv []string, stdin []byte, lim limits.Limits) (*Outcome, error) {
+	runCtx, cancel := withWallLimit(ctx, lim)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: maxOutputBytes}
+	stderr := &limitedBuffer{limit: maxOutputBytes}
+
+	cmd := s.command(runCtx, argv)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	var used usage
+	err := startOn(ctx, cmd)
+	if err == nil {
+		stop := watch(cmd.Process.Pid, lim, 0)
+		err = cmd.Wait()
+		used = stop()
+	}
+	out := &Outcome{
+		Stdout:   stdout.Bytes(),
+		Stderr:   stderr.Bytes(),
+		WallTime: time.Since(start),
+	}
+	err = fillStatus(out, cmd, err)
+	if err == nil {
+		enforce(out, lim, used)
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		err = ctx.Err()
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		out.TimedOut = true
+		out.ExitCode = -1
+		err = nil
+	}
+	s.logOutcome(argv, out, err)
+	return out, err
+}
+
+// withWallLimit bounds ctx by the wall-clock limit, if there is one.
+func withWallLimit(ctx context.Context, lim limits.Limits) (context.Context, context.CancelFunc) {
+	if lim.WallMs <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, lim.Wall())
+}
+
+func (s *Sandbox) command(ctx context.Context, argv []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = s.Dir
+	cmd.Env = []string{"PATH=/usr/local/bin:/usr/bin:/bin", "HOME=" + s.Dir}
+	// Run in its own process group so a timeout also kills anything the
+	// submission forked.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+// fillStatus records how the finished command exited and what it used. It
+// returns err unless it only reports a non-zero exit.
+func fillStatus(out *Outcome, cmd *exec.Cmd, err error) error {
+	if ru, ok := processUsage(cmd); ok {
+		out.CPUTime = time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+		out.PeakMemoryKB = ru.Maxrss
+	}
+	if cmd.ProcessState != nil {
+		if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			out.Signal = int(ws.Signal())
+		}
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		out.ExitCode = exitErr.ExitCode()
+	default:
+		return err
+	}
+	return nil
+}
+
+// processUsage returns the rusage of the finished process. On Linux Maxrss
+// is reported in kilobytes.
+func processUsage(cmd *exec.Cmd) (*syscall.Rusage, bool) {
+	if cmd.ProcessState == nil {
+		return nil, false
+	}
+	ru, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage)
+	return ru, ok
+}
+
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
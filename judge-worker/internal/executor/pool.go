@@ -0,0 +1,323 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"judge-worker/internal/language"
+	"judge-worker/internal/limits"
+)
+
+// respawnDelay keeps a pool whose launcher keeps failing from spinning.
+const respawnDelay = time.Second
+
+// Runtime pool counters of the process, served on /debug/vars. Saved time
+// is the startup the runs served warm did not have to wait for.
+var (
+	poolHits    = expvar.NewInt("runtime_pool_hits")
+	poolMisses  = expvar.NewInt("runtime_pool_misses")
+	poolSavedMs = expvar.NewInt("runtime_pool_saved_ms")
+)
+
+// Pool keeps interpreters of one language started ahead of time through the
+// recipe's Warm launcher. Each warm process serves a single run and is
+// replaced, so nothing a submission leaves behind reaches the next one.
+type Pool struct {
+	recipe language.Recipe
+	dir    string
+
+	mu     sync.Mutex
+	idle   []*warmProcess
+	closed bool
+}
+
+// warmProcess is a launcher waiting for its request. The pipes are the
+// parent's ends; the child's were closed once it started.
+type warmProcess struct {
+	cmd     *exec.Cmd
+	control *os.File
+	stdin   *os.File
+	stdout  *os.File
+	stderr  *os.File
+	startup time.Duration
+}
+
+type warmRequest struct {
+	Dir  string   `json:"dir"`
+	Argv []string `json:"argv"`
+}
+
+// Prewarm keeps size processes of the recipe's language ready for the
+// sandboxes created afterwards. It must be called before any sandbox is.
+func (e *Executor) Prewarm(recipe language.Recipe, size int) error {
+	if len(recipe.Warm) == 0 {
+		return errors.New("executor: " + recipe.Name + " cannot be prewarmed")
+	}
+
+	dir := filepath.Join(e.root, "warm-"+recipe.Name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	p := &Pool{recipe: recipe, dir: dir}
+	for i := 0; i < size; i++ {
+		go p.spawn()
+	}
+	if e.pools == nil {
+		e.pools = make(map[string]*Pool)
+	}
+	e.pools[recipe.Name] = p
+	return nil
+}
+
+// Close stops the processes of every pool.
+func (e *Executor) Close() {
+	for _, p := range e.pools {
+		p.close()
+	}
+}
+
+// take returns a ready process and starts its replacement, or returns nil
+// when none is ready and the run has to start cold.
+func (p *Pool) take() *warmProcess {
+	p.mu.Lock()
+	var wp *warmProcess
+	if n := len(p.idle); n > 0 && !p.closed {
+		wp = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+	}
+	p.mu.Unlock()
+
+	if wp == nil {
+		poolMisses.Add(1)
+		return nil
+	}
+	poolHits.Add(1)
+	poolSavedMs.Add(wp.startup.Milliseconds())
+	go p.spawn()
+	return wp
+}
+
+// spawn starts one launcher and adds it to the pool once it is ready.
+func (p *Pool) spawn() {
+	for {
+		wp, err := p.start()
+		if err == nil {
+			p.mu.Lock()
+			closed := p.closed
+			if !closed {
+				p.idle = append(p.idle, wp)
+			}
+			p.mu.Unlock()
+			if closed {
+				wp.discard()
+			}
+			return
+		}
+
+		log.Printf("runtime pool %s: %v", p.recipe.Name, err)
+		time.Sleep(respawnDelay)
+
+		p.mu.Lock()
+		closed := p.closed
+		p.mu.Unlock()
+		if closed {
+			return
+		}
+	}
+}
+
+// start launches the Warm command and waits until it reports ready.
+func (p *Pool) start() (*warmProcess, error) {
+	var parent, child []*os.File
+	pipe := func(childReads bool) (*os.File, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		if childReads {
+			child, parent = append(child, r), append(parent, w)
+			return w, nil
+		}
+		child, parent = append(child, w), append(parent, r)
+		return r, nil
+	}
+	closeAll := func(files []*os.File) {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	wp := &warmProcess{}
+	var ready *os.File
+	var err error
+	for _, end := range []struct {
+		f          **os.File
+		childReads bool
+	}{
+		{&wp.stdin, true},
+		{&wp.stdout, false},
+		{&wp.stderr, false},
+		{&wp.control, true},
+		{&ready, false},
+	} {
+		if *end.f, err = pipe(end.childReads); err != nil {
+			closeAll(parent)
+			closeAll(child)
+			return nil, err
+		}
+	}
+
+	argv := p.recipe.Warm
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = p.dir
+	cmd.Env = []string{"PATH=/usr/local/bin:/usr/bin:/bin", "HOME=" + p.dir}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = child[0], child[1], child[2]
+	cmd.ExtraFiles = child[3:]
+	wp.cmd = cmd
+
+	started := time.Now()
+	err = cmd.Start()
+	closeAll(child)
+	if err != nil {
+		closeAll(parent)
+		return nil, err
+	}
+
+	buf := make([]byte, 1)
+	_, err = io.ReadFull(ready, buf)
+	ready.Close()
+	if err != nil {
+		wp.discard()
+		return nil, errors.New("launcher exited before it was ready")
+	}
+	wp.startup = time.Since(started)
+	return wp, nil
+}
+
+func (p *Pool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.mu.Unlock()
+
+	for _, wp := range idle {
+		wp.discard()
+	}
+}
+
+// discard stops a process that will not serve a run.
+func (wp *warmProcess) discard() {
+	syscall.Kill(-wp.cmd.Process.Pid, syscall.SIGKILL)
+	wp.closePipes()
+	wp.cmd.Wait()
+}
+
+func (wp *warmProcess) closePipes() {
+	for _, f := range []*os.File{wp.control, wp.stdin, wp.stdout, wp.stderr} {
+		f.Close()
+	}
+}
+
+// execWarm runs argv on a warm process as exec would have started it cold.
+// Time and CPU are counted from the request on, so the saved startup does
+// not count against the submission.
+func (s *Sandbox) execWarm(ctx context.Context, wp *warmProcess, argv []string, stdin []byte, lim limits.Limits) (*Outcome, error) {
+	defer wp.closePipes()
+
+	runCtx, cancel := withWallLimit(ctx, lim)
+	defer cancel()
+
+	request, err := json.Marshal(warmRequest{Dir: s.Dir, Argv: argv[1:]})
+	if err != nil {
+		wp.discard()
+		return nil, err
+	}
+
+	pid := wp.cmd.Process.Pid
+	if cpu, ok := cpuFrom(ctx); ok {
+		if err := pin(pid, cpu); err != nil {
+			wp.discard()
+			return nil, err
+		}
+	}
+	baseCPU, _ := processCPUTime(pid)
+
+	start := time.Now()
+	_, err = wp.control.Write(request)
+	wp.control.Close()
+	if err != nil {
+		wp.discard()
+		return nil, err
+	}
+
+	stdout := &limitedBuffer{limit: maxOutputBytes}
+	stderr := &limitedBuffer{limit: maxOutputBytes}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		io.Copy(stdout, wp.stdout)
+		wg.Done()
+	}()
+	go func() {
+		io.Copy(stderr, wp.stderr)
+		wg.Done()
+	}()
+	go func() {
+		// Fails with EPIPE if the program exits without reading it all.
+		wp.stdin.Write(stdin)
+		wp.stdin.Close()
+	}()
+
+	stop := watch(pid, lim, baseCPU)
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-runCtx.Done():
+			syscall.Kill(-pid, syscall.SIGKILL)
+		case <-exited:
+		}
+	}()
+
+	err = wp.cmd.Wait()
+	used := stop()
+	close(exited)
+	// Anything the submission forked is killed with it, so no one keeps the
+	// output pipes open.
+	syscall.Kill(-pid, syscall.SIGKILL)
+	wg.Wait()
+
+	out := &Outcome{
+		Stdout:   stdout.Bytes(),
+		Stderr:   stderr.Bytes(),
+		WallTime: time.Since(start),
+	}
+	err = fillStatus(out, wp.cmd, err)
+	out.CPUTime = max(0, out.CPUTime-baseCPU)
+	if err == nil {
+		enforce(out, lim, used)
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		err = ctx.Err()
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		out.TimedOut = true
+		out.ExitCode = -1
+		err = nil
+	}
+	s.logf("warm start, saved %s", wp.startup)
+	s.logOutcome(argv, out, err)
+	return out, err
+}
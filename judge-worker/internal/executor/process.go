@@ -0,0 +1,163 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"judge-worker/internal/limits"
+)
+
+// clockTicks is the kernel's USER_HZ, the unit of times in /proc/<pid>/stat.
+const clockTicks = 100
+
+// Process is a program started in the background with caller-provided
+// pipes, for judging modes where the caller has to drive its I/O.
+type Process struct {
+	sandbox *Sandbox
+	argv    []string
+	cmd     *exec.Cmd
+	stderr  *limitedBuffer
+	started time.Time
+	limits  limits.Limits
+	stop    func() usage
+}
+
+// Start launches the recipe's run command with extra arguments. The process
+// is killed when ctx is done or once it exceeds the CPU time or memory of
+// lim; its wall-clock time is left to ctx.
+func (s *Sandbox) Start(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, lim limits.Limits) (*Process, error) {
+	argv := append(append([]string{}, s.recipe.Run...), args...)
+	stderr := &limitedBuffer{limit: maxOutputBytes}
+
+	cmd := s.command(ctx, argv)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := startOn(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	return &Process{
+		sandbox: s,
+		argv:    argv,
+		cmd:     cmd,
+		stderr:  stderr,
+		started: time.Now(),
+		limits:  lim,
+		stop:    watch(cmd.Process.Pid, lim, 0),
+	}, nil
+}
+
+// Wait blocks until the process exits and reports how it went. Stdout is
+// not captured, it went wherever the caller sent it.
+func (p *Process) Wait() (*Outcome, error) {
+	err := p.cmd.Wait()
+	used := p.stop()
+	out := &Outcome{Stderr: p.stderr.Bytes(), WallTime: time.Since(p.started)}
+	err = fillStatus(out, p.cmd, err)
+	if err == nil {
+		enforce(out, p.limits, used)
+	}
+	p.sandbox.logOutcome(p.argv, out, err)
+	return out, err
+}
+
+// CPUTime reports the CPU time the process has used so far.
+func (p *Process) CPUTime() (time.Duration, error) {
+	return processCPUTime(p.cmd.Process.Pid)
+}
+
+// processCPUTime reads the CPU time the process group led by pid has used
+// so far, as groupStat counts it.
+func processCPUTime(pid int) (time.Duration, error) {
+	cpu, _, err := groupStat(pid)
+	return cpu, err
+}
+
+// groupStat sums the CPU time and resident set size of every process in
+// the group led by pid, so a submission cannot hide usage in processes it
+// forks. CPU time includes the children members have waited for, as rusage
+// does; pages shared between members count once per member, which errs on
+// the strict side. It fails once the leader has been reaped.
+func groupStat(pid int) (time.Duration, int64, error) {
+	leader, err := processStat(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+	cpu, rssKB := leader.cpu, leader.rssKB
+
+	des, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, de := range des {
+		member, err := strconv.Atoi(de.Name())
+		if err != nil || member == pid {
+			continue
+		}
+		st, err := processStat(member)
+		if err != nil || st.pgrp != pid {
+			// Gone in the meantime, or in another group.
+			continue
+		}
+		cpu += st.cpu
+		rssKB += st.rssKB
+	}
+	return cpu, rssKB, nil
+}
+
+// procStat is what /proc/<pid>/stat tells about one process.
+type procStat struct {
+	pgrp int
+	// cpu includes the children the process has waited for.
+	cpu   time.Duration
+	rssKB int64
+}
+
+func processStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, err
+	}
+
+	// The command name in field 2 may contain spaces, so count fields from
+	// the closing parenthesis. pgrp is field 5, utime, stime, cutime and
+	// cstime are fields 14 to 17, rss in pages is field 24.
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return procStat{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 22 {
+		return procStat{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+
+	pgrp, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return procStat{}, err
+	}
+	var ticks int64
+	for _, f := range fields[11:15] {
+		n, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return procStat{}, err
+		}
+		ticks += n
+	}
+	rss, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return procStat{}, err
+	}
+	return procStat{
+		pgrp:  pgrp,
+		cpu:   time.Duration(ticks) * time.Second / clockTicks,
+		rssKB: rss * int64(os.Getpagesize()) / 1024,
+	}, nil
+}
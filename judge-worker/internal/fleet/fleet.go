@@ -0,0 +1,39 @@
+package fleet
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "worker-languages:"
+
+// Advertise records which languages a worker can judge. The entry expires
+// after ttl, so workers refresh it periodically and dead pods drop out.
+func Advertise(ctx context.Context, rdb *redis.Client, consumerID string, languages []string, ttl time.Duration) error {
+	return rdb.Set(ctx, keyPrefix+consumerID, strings.Join(languages, ","), ttl).Err()
+}
+
+// Languages counts the live workers advertising each language.
+func Languages(ctx context.Context, rdb *redis.Client) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	iter := rdb.Scan(ctx, 0, keyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		v, err := rdb.Get(ctx, iter.Val()).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, lang := range strings.Split(v, ",") {
+			if lang != "" {
+				counts[lang]++
+			}
+		}
+	}
+	return counts, iter.Err()
+}
@@ -0,0 +1,235 @@
+package interactor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"judge-worker/internal/executor"
+	"judge-worker/internal/language"
+	"judge-worker/internal/limits"
+)
+
+const (
+	compileTimeout = 30 * time.Second
+
+	// A run is considered deadlocked when neither side has used any CPU for
+	// idleLimit, typically because both are blocked reading from each other.
+	idleLimit     = time.Second
+	idleCheckTick = 100 * time.Millisecond
+)
+
+var errIdle = errors.New("interactor: both processes idle")
+
+// Interactor is a problem's interaction program. It is started as
+//
+//	interactor <input> <expected>
+//
+// with its stdout connected to the submission's stdin and the submission's
+// stdout connected to its stdin. It accepts by exiting 0 or rejects by
+// exiting 1; any other exit is an interactor failure.
+type Interactor struct {
+	sb *executor.Sandbox
+}
+
+// Result describes one interactive run.
+type Result struct {
+	// Contestant is how the submission exited. Its stdout went to the
+	// interactor, so it is not captured. TimedOut is set when the combined
+	// wall-clock limit or the contestant's CPU time ran out.
+	Contestant *executor.Outcome
+
+	// Idle is set when the run was stopped as deadlocked.
+	Idle bool
+
+	// Accepted is the interactor's verdict. It is only meaningful when the
+	// run was not idle and the contestant stayed within its limits.
+	Accepted bool
+}
+
+// New builds the interactor in its own sandbox. Close releases it.
+func New(ctx context.Context, ex *executor.Executor, lang, source string) (*Interactor, error) {
+	recipe, ok := language.Lookup(lang)
+	if !ok {
+		return nil, fmt.Errorf("interactor: unsupported language %q", lang)
+	}
+
+	sb, err := ex.NewSandbox(recipe, source)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := sb.Compile(ctx, compileTimeout)
+	if err != nil {
+		sb.Close()
+		return nil, err
+	}
+	if !out.OK() {
+		sb.Close()
+		return nil, fmt.Errorf("interactor: compilation failed: %s", out.Stderr)
+	}
+	return &Interactor{sb: sb}, nil
+}
+
+func (it *Interactor) Close() error {
+	return it.sb.Close()
+}
+
+// Run plays one test: the contestant sandbox's program against the
+// interactor. Both share the wall-clock limit of lim, while its CPU time and
+// memory limits apply to the contestant alone.
+func (it *Interactor) Run(ctx context.Context, contestant *executor.Sandbox, input, expected string, lim limits.Limits) (*Result, error) {
+	// Tests running side by side share the interactor's sandbox, so every
+	// run writes files of its own.
+	files := []struct{ pattern, data string }{
+		{"interactor_input-*.txt", input},
+		{"interactor_expected-*.txt", expected},
+	}
+	args := make([]string, 0, len(files))
+	defer func() {
+		for _, name := range args {
+			it.sb.Remove(name)
+		}
+	}()
+	for _, f := range files {
+		name, err := it.sb.WriteTemp(f.pattern, []byte(f.data))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, name)
+	}
+
+	// One pipe carries the interactor's output to the contestant, the
+	// other the contestant's output back.
+	contestantIn, interactorOut, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	interactorIn, contestantOut, err := os.Pipe()
+	if err != nil {
+		contestantIn.Close()
+		interactorOut.Close()
+		return nil, err
+	}
+	ends := []*os.File{contestantIn, interactorOut, interactorIn, contestantOut}
+	closeEnds := func() {
+		for _, f := range ends {
+			f.Close()
+		}
+	}
+
+	idleCtx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	runCtx, cancel := context.WithTimeout(idleCtx, lim.Wall())
+	defer cancel()
+
+	sub, err := contestant.Start(runCtx, nil, contestantIn, contestantOut, limits.Limits{TimeMs: lim.TimeMs, MemoryKB: lim.MemoryKB})
+	if err != nil {
+		closeEnds()
+		return nil, err
+	}
+	inter, err := it.sb.Start(runCtx, args, interactorIn, interactorOut, limits.Limits{})
+	if err != nil {
+		closeEnds()
+		cancel()
+		sub.Wait()
+		return nil, err
+	}
+	// Both children hold their own copies now. Dropping ours lets each side
+	// see EOF or SIGPIPE once the other one exits.
+	closeEnds()
+
+	var subOut, interOut *executor.Outcome
+	var subErr, interErr error
+	subDone := make(chan struct{})
+	interDone := make(chan struct{})
+	go func() {
+		subOut, subErr = sub.Wait()
+		close(subDone)
+	}()
+	go func() {
+		interOut, interErr = inter.Wait()
+		close(interDone)
+	}()
+
+	watchIdle(runCtx, stop, subDone, interDone, sub, inter)
+	<-subDone
+	<-interDone
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if subErr != nil {
+		return nil, subErr
+	}
+	if interErr != nil {
+		return nil, interErr
+	}
+
+	res := &Result{Contestant: subOut}
+	switch {
+	case context.Cause(idleCtx) == errIdle:
+		res.Idle = true
+		subOut.ExitCode = -1
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		subOut.TimedOut = true
+		subOut.ExitCode = -1
+	case subOut.TimedOut || subOut.MemoryExceeded:
+		// Killed for its own limits; the interactor only saw it vanish.
+	default:
+		// A contestant that was still writing when the interactor gave up
+		// dies of SIGPIPE; that is the interactor's verdict, not a crash.
+		if subOut.Signal == int(syscall.SIGPIPE) {
+			subOut.Signal = 0
+			subOut.ExitCode = 0
+		}
+		switch interOut.ExitCode {
+		case 0:
+			res.Accepted = true
+		case 1:
+		default:
+			return nil, fmt.Errorf("interactor: exited with %d: %s", interOut.ExitCode, interOut.Stderr)
+		}
+	}
+	return res, nil
+}
+
+// watchIdle polls both processes until they have exited, and stops the run
+// with errIdle when neither made CPU progress for idleLimit.
+func watchIdle(ctx context.Context, stop context.CancelCauseFunc, subDone, interDone <-chan struct{}, procs ...*executor.Process) {
+	ticker := time.NewTicker(idleCheckTick)
+	defer ticker.Stop()
+
+	var last time.Duration
+	lastProgress := time.Now()
+	for {
+		select {
+		case <-subDone:
+			// Once the contestant is gone the interactor sees EOF and
+			// finishes on its own or runs into the wall-clock limit.
+			return
+		case <-interDone:
+			return
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			var used time.Duration
+			for _, p := range procs {
+				if cpu, err := p.CPUTime(); err == nil {
+					used += cpu
+				}
+			}
+			if used != last {
+				last, lastProgress = used, now
+				continue
+			}
+			if now.Sub(lastProgress) >= idleLimit {
+				stop(errIdle)
+				return
+			}
+		}
+	}
+}
@@ -1,8 +1,32 @@
 package job
 
+// Job is a submission to judge. Its source is stored once as a blob and
+// referenced by SourceHash; Source is only filled in inline by older
+// producers and by workers once they have fetched the blob.
+//
+// Multi-file submissions carry Files, keyed by relative path, instead of
+// Source, and their blob is the JSON of that map. They name the file to
+// start from in Entrypoint or a build script in BuildFile. Clients may send
+// the files as an Archive instead, which the API unpacks.
 type Job struct {
 	SubmissionID string `json:"submission_id"`
 	UserID       string `json:"user_id"`
 	Language     string `json:"language"`
 	Tier         string `json:"tier"`
+	ProblemID    string `json:"problem_id,omitempty"`
+	Source       string `json:"source,omitempty"`
+	SourceHash   string `json:"source_hash,omitempty"`
+	Promoted     bool   `json:"promoted,omitempty"`
+
+	Files         map[string]string `json:"files,omitempty"`
+	Entrypoint    string            `json:"entrypoint,omitempty"`
+	BuildFile     string            `json:"build_file,omitempty"`
+	Archive       []byte            `json:"archive,omitempty"`
+	ArchiveFormat string            `json:"archive_format,omitempty"`
+}
+
+// MultiFile reports whether the job is a project of several files rather
+// than a single source.
+func (j Job) MultiFile() bool {
+	return len(j.Files) > 0 || j.Entrypoint != "" || j.BuildFile != ""
 }
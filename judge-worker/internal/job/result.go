@@ -1,6 +1,28 @@
 package job
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	StatusAccepted              = "ACCEPTED"
+	StatusWrongAnswer           = "WRONG_ANSWER"
+	StatusCancelled             = "CANCELLED"
+	StatusCompileError          = "COMPILE_ERROR"
+	StatusRuntimeError          = "RUNTIME_ERROR"
+	StatusTimeLimitExceeded     = "TIME_LIMIT_EXCEEDED"
+	StatusMemoryLimitExceeded   = "MEMORY_LIMIT_EXCEEDED"
+	StatusIdlenessLimitExceeded = "IDLENESS_LIMIT_EXCEEDED"
+	StatusInternalError         = "INTERNAL_ERROR"
+)
+
+// CompileOutputBytes caps the compiler diagnostics a COMPILE_ERROR carries
+// through the results stream into the submissions table and back out of the
+// API.
+const CompileOutputBytes = 8 << 10
 
 type ResultEvent struct {
 	SubmissionID string    `db:"submission_id" json:"submission_id"`
@@ -8,6 +30,75 @@ type ResultEvent struct {
 	Tier         string    `db:"tier" json:"tier"`
 	Language     string    `db:"language" json:"language"`
 	ExecutionMs  int       `db:"execution_ms" json:"execution_ms"`
+	CPUMs        int       `db:"cpu_ms" json:"cpu_ms"`
+	WallMs       int       `db:"wall_ms" json:"wall_ms"`
+	PeakRSSKB    int64     `db:"peak_rss_kb" json:"peak_rss_kb"`
+	ExitCode     int       `db:"exit_code" json:"exit_code"`
+	Signal       int       `db:"term_signal" json:"signal,omitempty"`
 	Status       string    `db:"status" json:"status"`
+	Score        float64   `db:"score" json:"score"`
 	CompletedAt  time.Time `db:"completed_at" json:"completed_at"`
+	Promoted     bool      `db:"promoted" json:"promoted"`
+
+	// CompileOutput holds the compiler's diagnostics of a COMPILE_ERROR.
+	CompileOutput string `db:"compile_output" json:"compile_output,omitempty"`
+
+	// Cached results were copied from an identical earlier submission
+	// instead of being judged again.
+	Cached bool `db:"cached" json:"cached"`
+
+	// The limits the submission ran under: CPU time, wall-clock time and
+	// memory, after the language and tier adjustments.
+	TimeLimitMs   int   `db:"time_limit_ms" json:"time_limit_ms"`
+	WallLimitMs   int   `db:"wall_limit_ms" json:"wall_limit_ms"`
+	MemoryLimitKB int64 `db:"memory_limit_kb" json:"memory_limit_kb"`
+}
+
+// TestResult is the outcome of one test case of a submission.
+type TestResult struct {
+	SubmissionID string  `db:"submission_id" json:"-"`
+	TestIndex    int     `db:"test_index" json:"test_index"`
+	ProblemID    string  `db:"problem_id" json:"-"`
+	Hidden       bool    `db:"hidden" json:"hidden"`
+	Verdict      string  `db:"verdict" json:"verdict"`
+	Score        float64 `db:"score" json:"score"`
+	CPUMs        int     `db:"cpu_ms" json:"cpu_ms"`
+	WallMs       int     `db:"wall_ms" json:"wall_ms"`
+	PeakMemoryKB int64   `db:"peak_memory_kb" json:"peak_memory_kb"`
+	ExitCode     int     `db:"exit_code" json:"exit_code"`
+	// Diff is only produced for wrong answers on tests that are not hidden.
+	Diff *Diff `db:"diff" json:"diff,omitempty"`
+}
+
+// SubtaskScore is the points a submission earned on one subtask.
+type SubtaskScore struct {
+	SubmissionID string  `db:"submission_id" json:"-"`
+	SubtaskIndex int     `db:"subtask_index" json:"subtask_index"`
+	Points       float64 `db:"points" json:"points"`
+	Score        float64 `db:"score" json:"score"`
+}
+
+// Diff locates where a wrong answer first departs from the expected output.
+// Line and Column are 1-based; the snippets show the text around that spot
+// and are empty when the respective output has no such line.
+type Diff struct {
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// Value stores a diff as JSON.
+func (d Diff) Value() (driver.Value, error) {
+	return json.Marshal(d)
+}
+
+func (d *Diff) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	}
+	return fmt.Errorf("job: cannot scan %T into Diff", src)
 }
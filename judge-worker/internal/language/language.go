@@ -0,0 +1,206 @@
+package language
+
+import (
+	"sort"
+	"strings"
+)
+
+// Recipe describes how the judge builds and runs one language. Commands run
+// inside the sandbox directory, which holds the submission as SourceFile.
+// Interpreted languages leave Compile empty.
+//
+// ProjectCompile and ProjectRun are the commands for multi-file submissions,
+// where these placeholders are expanded: {entrypoint} is the file to start
+// from, {sources} every file with SourceExt and {class} the entrypoint as a
+// Java class name. Without them the single-file commands are used with
+// SourceFile replaced by the entrypoint.
+//
+// Warm, when set, starts the interpreter ahead of time for a runtime pool.
+// It writes a byte to file descriptor 4 once it is ready, then reads a JSON
+// request {"dir": ..., "argv": [...]} from descriptor 3 and behaves as if
+// Run[0] had been started in dir with argv. It exits quietly when
+// descriptor 3 is closed without a request.
+type Recipe struct {
+	Name             string
+	Version          string
+	SourceFile       string
+	SourceExt        string
+	Compile          []string
+	Run              []string
+	ProjectCompile   []string
+	ProjectRun       []string
+	Warm             []string
+	TimeMultiplier   float64
+	MemoryMultiplier float64
+}
+
+var registry = map[string]Recipe{
+	"c": {
+		Name:             "c",
+		Version:          "gcc 13 (C11)",
+		SourceFile:       "main.c",
+		SourceExt:        ".c",
+		Compile:          []string{"gcc", "-O2", "-std=c11", "-o", "main", "main.c", "-lm"},
+		Run:              []string{"./main"},
+		ProjectCompile:   []string{"gcc", "-O2", "-std=c11", "-o", "main", "{sources}", "-lm"},
+		TimeMultiplier:   1,
+		MemoryMultiplier: 1,
+	},
+	"cpp": {
+		Name:             "cpp",
+		Version:          "g++ 13 (C++17)",
+		SourceFile:       "main.cpp",
+		SourceExt:        ".cpp",
+		Compile:          []string{"g++", "-O2", "-std=c++17", "-o", "main", "main.cpp"},
+		Run:              []string{"./main"},
+		ProjectCompile:   []string{"g++", "-O2", "-std=c++17", "-o", "main", "{sources}"},
+		TimeMultiplier:   1,
+		MemoryMultiplier: 1,
+	},
+	"java": {
+		Name:             "java",
+		Version:          "OpenJDK 21",
+		SourceFile:       "Main.java",
+		SourceExt:        ".java",
+		Compile:          []string{"javac", "Main.java"},
+		Run:              []string{"java", "-Xss64m", "Main"},
+		ProjectCompile:   []string{"javac", "{sources}"},
+		ProjectRun:       []string{"java", "-Xss64m", "{class}"},
+		TimeMultiplier:   2,
+		MemoryMultiplier: 2,
+	},
+	"javascript": {
+		Name:             "javascript",
+		Version:          "Node.js 20",
+		SourceFile:       "main.js",
+		SourceExt:        ".js",
+		Run:              []string{"node", "main.js"},
+		Warm:             []string{"node", "-e", nodeLauncher},
+		TimeMultiplier:   2,
+		MemoryMultiplier: 1.5,
+	},
+	"python": {
+		Name:             "python",
+		Version:          "CPython 3.12",
+		SourceFile:       "main.py",
+		SourceExt:        ".py",
+		Run:              []string{"python3", "main.py"},
+		Warm:             []string{"python3", "-c", pythonLauncher},
+		TimeMultiplier:   3,
+		MemoryMultiplier: 1.5,
+	},
+	"rust": {
+		Name:             "rust",
+		Version:          "rustc 1.78",
+		SourceFile:       "main.rs",
+		SourceExt:        ".rs",
+		Compile:          []string{"rustc", "-O", "-o", "main", "main.rs"},
+		Run:              []string{"./main"},
+		TimeMultiplier:   1,
+		MemoryMultiplier: 1,
+	},
+}
+
+// Launchers implementing the Warm protocol.
+const (
+	pythonLauncher = `import json, os, runpy, sys
+os.write(4, b"1")
+os.close(4)
+with os.fdopen(3) as control:
+    request = control.read()
+if not request:
+    sys.exit(0)
+request = json.loads(request)
+os.chdir(request["dir"])
+os.environ["HOME"] = request["dir"]
+sys.argv = request["argv"]
+sys.path[0] = os.path.dirname(os.path.abspath(sys.argv[0]))
+runpy.run_path(sys.argv[0], run_name="__main__")
+`
+
+	nodeLauncher = `const fs = require("fs");
+const path = require("path");
+fs.writeSync(4, "1");
+fs.closeSync(4);
+const request = fs.readFileSync(3, "utf8");
+if (!request) process.exit(0);
+const { dir, argv } = JSON.parse(request);
+process.chdir(dir);
+process.env.HOME = dir;
+process.argv = [process.argv[0], path.resolve(argv[0]), ...argv.slice(1)];
+require("module").runMain();
+`
+)
+
+func Lookup(name string) (Recipe, bool) {
+	r, ok := registry[name]
+	return r, ok
+}
+
+// Names lists the supported languages in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Project adapts the recipe to a multi-file submission holding files, started
+// from entrypoint. A non-empty buildFile is run with sh instead of the
+// recipe's own compile step.
+func (r Recipe) Project(files []string, entrypoint, buildFile string) Recipe {
+	var sources []string
+	for _, f := range files {
+		if strings.HasSuffix(f, r.SourceExt) {
+			sources = append(sources, f)
+		}
+	}
+	sort.Strings(sources)
+	class := strings.ReplaceAll(strings.TrimSuffix(entrypoint, r.SourceExt), "/", ".")
+
+	expand := func(project, single []string) []string {
+		if project == nil {
+			project = single
+		}
+		var argv []string
+		for _, arg := range project {
+			switch arg {
+			case "{sources}":
+				argv = append(argv, sources...)
+			case "{entrypoint}", r.SourceFile:
+				argv = append(argv, entrypoint)
+			case "{class}":
+				argv = append(argv, class)
+			default:
+				argv = append(argv, arg)
+			}
+		}
+		return argv
+	}
+
+	p := r
+	p.SourceFile = entrypoint
+	p.Compile = expand(r.ProjectCompile, r.Compile)
+	p.Run = expand(r.ProjectRun, r.Run)
+	if buildFile != "" {
+		p.Compile = []string{"sh", buildFile}
+	}
+	return p
+}
+
+func (r Recipe) Compiled() bool {
+	return len(r.Compile) > 0
+}
+
+// Binaries lists the toolchain executables the recipe needs on PATH.
+func (r Recipe) Binaries() []string {
+	var bins []string
+	for _, cmd := range [][]string{r.Compile, r.Run} {
+		if len(cmd) > 0 && !strings.HasPrefix(cmd[0], "./") {
+			bins = append(bins, cmd[0])
+		}
+	}
+	return bins
+}
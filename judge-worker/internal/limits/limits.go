@@ -0,0 +1,63 @@
+package limits
+
+import (
+	"math"
+	"time"
+
+	"judge-worker/internal/language"
+)
+
+// Limits are the resources one run of a submission may use. A zero field
+// leaves that resource unlimited.
+type Limits struct {
+	// TimeMs is the CPU time limit.
+	TimeMs int
+	// WallMs bounds the run's wall-clock time, which also covers time
+	// spent blocked on I/O or waiting for a core.
+	WallMs   int
+	MemoryKB int64
+}
+
+func (l Limits) Time() time.Duration {
+	return time.Duration(l.TimeMs) * time.Millisecond
+}
+
+func (l Limits) Wall() time.Duration {
+	return time.Duration(l.WallMs) * time.Millisecond
+}
+
+// Wall limits a run by wall-clock time only, for programs of the judge
+// itself such as checkers.
+func Wall(d time.Duration) Limits {
+	return Limits{WallMs: int(d.Milliseconds())}
+}
+
+// Policy is how a tier's limits relate to the base limits. WallGrace is the
+// wall-clock time allowed on top of the time limit, as a fraction of it.
+type Policy struct {
+	WallGrace float64
+}
+
+// policies are keyed by tier. Changing one can change verdicts, so the
+// worker's judge version has to be bumped along with it.
+var policies = map[string]Policy{
+	"free":    {WallGrace: 0.5},
+	"premium": {WallGrace: 1},
+}
+
+// Effective computes the limits a submission in the recipe's language runs
+// under for the given tier, from a problem's base time and memory limits.
+// Unknown tiers get the free tier's policy.
+func Effective(timeMs, memoryKB int, recipe language.Recipe, tier string) Limits {
+	policy, ok := policies[tier]
+	if !ok {
+		policy = policies["free"]
+	}
+
+	t := math.Ceil(float64(timeMs) * recipe.TimeMultiplier)
+	return Limits{
+		TimeMs:   int(t),
+		WallMs:   int(math.Ceil(t * (1 + policy.WallGrace))),
+		MemoryKB: int64(math.Ceil(float64(memoryKB) * recipe.MemoryMultiplier)),
+	}
+}
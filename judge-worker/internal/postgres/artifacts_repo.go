@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ArtifactRef points at a stored artifact of a submission. Hidden artifacts
+// were produced on hidden tests and are not shown to the submitter.
+type ArtifactRef struct {
+	SubmissionID string    `db:"submission_id" json:"-"`
+	Name         string    `db:"name" json:"name"`
+	StoreKey     string    `db:"store_key" json:"-"`
+	SizeBytes    int       `db:"size_bytes" json:"size_bytes"`
+	Truncated    bool      `db:"truncated" json:"truncated"`
+	Hidden       bool      `db:"hidden" json:"hidden"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+// SaveArtifactRef records an artifact, replacing the one a previous judge
+// of the submission stored under the same name.
+func SaveArtifactRef(db *sqlx.DB, ref ArtifactRef) error {
+	_, err := db.NamedExec(`
+		INSERT INTO submission_artifacts (submission_id, name, store_key, size_bytes, truncated, hidden)
+		VALUES (:submission_id, :name, :store_key, :size_bytes, :truncated, :hidden)
+		ON CONFLICT (submission_id, name) DO UPDATE
+		SET store_key = EXCLUDED.store_key,
+		    size_bytes = EXCLUDED.size_bytes,
+		    truncated = EXCLUDED.truncated,
+		    hidden = EXCLUDED.hidden,
+		    created_at = NOW()`,
+		ref,
+	)
+	return err
+}
+
+func ListArtifactRefs(db *sqlx.DB, submissionID string) ([]ArtifactRef, error) {
+	var refs []ArtifactRef
+	err := db.Select(&refs, `
+		SELECT submission_id, name, store_key, size_bytes, truncated, hidden, created_at
+		FROM submission_artifacts
+		WHERE submission_id = $1
+		ORDER BY name ASC`,
+		submissionID)
+
+	return refs, err
+}
+
+// GetArtifactRef returns the named artifact of a submission, or nil if there
+// is none.
+func GetArtifactRef(db *sqlx.DB, submissionID, name string) (*ArtifactRef, error) {
+	var ref ArtifactRef
+	err := db.Get(&ref, `
+		SELECT submission_id, name, store_key, size_bytes, truncated, hidden, created_at
+		FROM submission_artifacts
+		WHERE submission_id = $1 AND name = $2`,
+		submissionID, name)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// PutBlobs stores blobs keyed by their content hash. Blobs that are already
+// stored are kept once; storing one again renews it, and locks it until the
+// transaction ends, so DeleteExpiredBlobs cannot drop it from under a new
+// reference.
+func PutBlobs(db sqlx.Ext, blobs map[string][]byte) error {
+	if len(blobs) == 0 {
+		return nil
+	}
+
+	hashes := make([]string, 0, len(blobs))
+	data := make([][]byte, 0, len(blobs))
+	for h, d := range blobs {
+		hashes = append(hashes, h)
+		data = append(data, d)
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO blobs (hash, data)
+		SELECT * FROM unnest($1::text[], $2::bytea[])
+		ON CONFLICT (hash) DO UPDATE SET created_at = NOW()`,
+		pq.Array(hashes), pq.Array(data))
+	return err
+}
+
+// GetBlob returns the blob stored under hash, or nil if there is none.
+func GetBlob(db *sqlx.DB, hash string) ([]byte, error) {
+	var data []byte
+	err := db.Get(&data, `
+		SELECT data
+		FROM blobs
+		WHERE hash = $1`,
+		hash)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// DeleteExpiredBlobs deletes up to limit blobs stored longer than retention
+// ago that no outbox entry references as its source. What remains of those
+// are payloads the relay passed by reference, which are only read while
+// their message is in a stream. It returns how many blobs were deleted.
+func DeleteExpiredBlobs(db *sqlx.DB, retention time.Duration, limit int) (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM blobs
+		WHERE hash IN (
+			SELECT b.hash
+			FROM blobs b
+			WHERE b.created_at < NOW() - make_interval(secs => $1)
+			  AND NOT EXISTS (SELECT 1 FROM job_outbox o WHERE o.payload->>'source_hash' = b.hash)
+			LIMIT $2
+		)
+		AND created_at < NOW() - make_interval(secs => $1)`,
+		retention.Seconds(), limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
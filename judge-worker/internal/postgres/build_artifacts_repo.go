@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func SaveBuildArtifact(db *sqlx.DB, submissionID string, data []byte) error {
+	_, err := db.Exec(`
+	INSERT INTO build_artifacts (submission_id, data)
+	VALUES ($1, $2)
+	ON CONFLICT (submission_id) DO UPDATE SET data = EXCLUDED.data, created_at = NOW()`,
+		submissionID, data)
+	return err
+}
+
+func GetBuildArtifact(db *sqlx.DB, submissionID string) ([]byte, error) {
+	var data []byte
+	err := db.Get(&data, `
+		SELECT data
+		FROM build_artifacts
+		WHERE submission_id = $1`,
+		submissionID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func DeleteBuildArtifact(db *sqlx.DB, submissionID string) error {
+	_, err := db.Exec(`DELETE FROM build_artifacts WHERE submission_id = $1`, submissionID)
+	return err
+}
+
+// ClaimCompile reserves the compile stage of a submission for one stream
+// message, so copies of a job queued in two streams are built only once. A
+// redelivery of the claiming message claims again, which is how a build
+// whose worker died is retried. It returns ErrAlreadyClaimed when another
+// message holds the claim.
+func ClaimCompile(db *sqlx.DB, submissionID, message, workerID string) error {
+	result, err := db.Exec(`
+	INSERT INTO compile_claims (submission_id, message, worker_id)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (submission_id) DO UPDATE
+	SET worker_id = EXCLUDED.worker_id, claimed_at = NOW()
+	WHERE compile_claims.message = EXCLUDED.message`,
+		submissionID, message, workerID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrAlreadyClaimed
+	}
+	return nil
+}
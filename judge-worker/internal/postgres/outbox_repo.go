@@ -1,12 +1,21 @@
 package postgres
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"judge-worker/internal/job"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// outboxInsertChunk keeps each multi-row insert well below the 65535
+// bind parameter limit of the postgres protocol.
+const outboxInsertChunk = 500
+
 type OutboxEntry struct {
 	ID           int64      `db:"id"`
 	SubmissionID string     `db:"submission_id"`
@@ -19,27 +28,93 @@ type OutboxEntry struct {
 	PublishedAt  *time.Time `db:"published_at"`
 }
 
-func InsertOutboxEntry(db *sqlx.DB, j job.Job, payload []byte) error {
-	_, err := db.Exec(`
+func GetOutboxEntry(db *sqlx.DB, submissionID string) (*OutboxEntry, error) {
+	var e OutboxEntry
+	err := db.Get(&e, `
+		SELECT id, submission_id, user_id, language, tier, payload, status, created_at, published_at
+		FROM job_outbox
+		WHERE submission_id = $1`,
+		submissionID,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// CancelPendingOutboxEntry marks the entry cancelled if the relay has not
+// published it yet. It returns nil when there was no pending entry to cancel.
+func CancelPendingOutboxEntry(tx *sqlx.Tx, submissionID string) (*OutboxEntry, error) {
+	var e OutboxEntry
+	err := tx.Get(&e, `
+		UPDATE job_outbox
+		SET status = 'cancelled'
+		WHERE submission_id = $1 AND status = 'pending'
+		RETURNING id, submission_id, user_id, language, tier, payload, status, created_at, published_at`,
+		submissionID,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// InsertOutboxEntry writes the entry together with the blobs its payload
+// references, so an entry never points at a blob that was not stored.
+func InsertOutboxEntry(db *sqlx.DB, j job.Job, payload []byte, blobs map[string][]byte) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := PutBlobs(tx, blobs); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
 	INSERT INTO job_outbox(submission_id, user_id, language, tier, payload)
 	VALUES ($1, $2, $3, $4, $5)
 	ON CONFLICT (submission_id) DO NOTHING`,
 		j.SubmissionID, j.UserID, j.Language, j.Tier, payload,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return tx.Commit()
 }
 
-func FetchAndLockPendingEntries(tx *sqlx.Tx, limit int) ([]OutboxEntry, error) {
+// FetchAndLockPendingEntries picks up to limit pending entries of a tier,
+// interleaving users round-robin: every user's oldest entry comes before
+// anyone's second, and so on. A burst from one user therefore only pushes
+// back that user's own submissions.
+//
+// With inLanguages set only entries in languages are returned; otherwise
+// only entries in none of them, which is how the general pool of a tier
+// picks up every language without a dedicated pool.
+func FetchAndLockPendingEntries(tx *sqlx.Tx, tier string, languages []string, inLanguages bool, limit int) ([]OutboxEntry, error) {
 	var entries []OutboxEntry
 	err := tx.Select(&entries, `
-		SELECT id, submission_id, user_id, language, tier, payload, status, created_at, published_at
-		FROM job_outbox
-		WHERE status = 'pending'
-		ORDER BY id ASC
-		LIMIT $1
-		FOR UPDATE SKIP LOCKED`,
-		limit,
+		SELECT o.id, o.submission_id, o.user_id, o.language, o.tier, o.payload, o.status, o.created_at, o.published_at
+		FROM job_outbox o
+		JOIN (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY id) AS user_rank
+			FROM job_outbox
+			WHERE status = 'pending' AND tier = $1 AND COALESCE(language = ANY($2), false) = $3
+		) r ON r.id = o.id
+		ORDER BY r.user_rank ASC, o.id ASC
+		LIMIT $4
+		FOR UPDATE OF o SKIP LOCKED`,
+		tier, pq.Array(languages), inLanguages, limit,
 	)
 
 	return entries, err
@@ -53,3 +128,108 @@ func MarkOutboxEntryPublished(tx *sqlx.Tx, id int64) error {
 	WHERE id = $1`, id)
 	return err
 }
+
+// MarkOutboxEntryCompleted retires the published entry of a submission once
+// its result is recorded, so in-flight bookkeeping such as aging only ever
+// looks at submissions that are still waiting.
+func MarkOutboxEntryCompleted(db sqlx.Execer, submissionID string) error {
+	_, err := db.Exec(`
+	UPDATE job_outbox
+	SET status = 'completed'
+	WHERE submission_id = $1 AND status = 'published'`, submissionID)
+	return err
+}
+
+// InsertOutboxEntries writes all entries and the blobs they reference in a
+// single transaction using multi-row inserts and returns the submission IDs
+// that were actually inserted. IDs that already existed in the outbox are
+// left out.
+func InsertOutboxEntries(db *sqlx.DB, entries []OutboxEntry, blobs map[string][]byte) (map[string]bool, error) {
+	inserted := make(map[string]bool, len(entries))
+	if len(entries) == 0 {
+		return inserted, nil
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := PutBlobs(tx, blobs); err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(entries); start += outboxInsertChunk {
+		end := min(start+outboxInsertChunk, len(entries))
+
+		var sb strings.Builder
+		sb.WriteString(`
+		INSERT INTO job_outbox(submission_id, user_id, language, tier, payload)
+		VALUES `)
+
+		args := make([]interface{}, 0, (end-start)*5)
+		for i, e := range entries[start:end] {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			n := len(args)
+			fmt.Fprintf(&sb, "($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5)
+			args = append(args, e.SubmissionID, e.UserID, e.Language, e.Tier, e.Payload)
+		}
+		sb.WriteString(`
+		ON CONFLICT (submission_id) DO NOTHING
+		RETURNING submission_id`)
+
+		var ids []string
+		if err := tx.Select(&ids, sb.String(), args...); err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			inserted[id] = true
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+// FetchAndLockAgedEntries returns entries of tier that were created more than
+// olderThan ago, have not been promoted yet and that no worker has claimed.
+// Users are interleaved round-robin as in FetchAndLockPendingEntries, so one
+// user's aged burst does not take every premium slot.
+func FetchAndLockAgedEntries(tx *sqlx.Tx, tier string, olderThan time.Duration, limit int) ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	err := tx.Select(&entries, `
+		SELECT o.id, o.submission_id, o.user_id, o.language, o.tier, o.payload, o.status, o.created_at, o.published_at
+		FROM job_outbox o
+		JOIN (
+			SELECT a.id, ROW_NUMBER() OVER (PARTITION BY a.user_id ORDER BY a.id) AS user_rank
+			FROM job_outbox a
+			WHERE a.tier = $1
+			  AND a.promoted_at IS NULL
+			  AND a.status IN ('pending', 'published')
+			  AND a.created_at < NOW() - make_interval(secs => $2)
+			  AND NOT EXISTS (SELECT 1 FROM processed_jobs p WHERE p.submission_id = a.submission_id)
+		) r ON r.id = o.id
+		ORDER BY r.user_rank ASC, o.id ASC
+		LIMIT $3
+		FOR UPDATE OF o SKIP LOCKED`,
+		tier, olderThan.Seconds(), limit,
+	)
+
+	return entries, err
+}
+
+func MarkOutboxEntryPromoted(tx *sqlx.Tx, id int64, payload []byte) error {
+	_, err := tx.Exec(`
+	UPDATE job_outbox
+	SET status = 'published',
+	payload = $2,
+	promoted_at = NOW(),
+	published_at = COALESCE(published_at, NOW())
+	WHERE id = $1`, id, payload)
+	return err
+}
@@ -0,0 +1,154 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"judge-worker/internal/problem"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// GetProblem loads a problem with its tests and subtasks in order, or nil if
+// it does not exist.
+func GetProblem(db *sqlx.DB, problemID string) (*problem.Problem, error) {
+	p, err := GetProblemInfo(db, problemID)
+	if err != nil || p == nil {
+		return nil, err
+	}
+
+	err = db.Select(&p.Tests, `
+		SELECT test_index, input, expected_output, hidden, subtask_index
+		FROM problem_tests
+		WHERE problem_id = $1
+		ORDER BY test_index ASC`,
+		problemID)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetProblemInfo loads a problem with its subtasks but without its tests,
+// or nil if it does not exist.
+func GetProblemInfo(db *sqlx.DB, problemID string) (*problem.Problem, error) {
+	var p problem.Problem
+	err := db.Get(&p, `
+		SELECT problem_id, version, time_limit_ms, memory_limit_kb,
+		       checker, checker_language, checker_source,
+		       interactive, interactor_language, interactor_source
+		FROM problems
+		WHERE problem_id = $1`,
+		problemID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Select(&p.Subtasks, `
+		SELECT subtask_index, points, rule
+		FROM problem_subtasks
+		WHERE problem_id = $1
+		ORDER BY subtask_index ASC`,
+		problemID)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range p.Subtasks {
+		if err := st.Validate(); err != nil {
+			return nil, fmt.Errorf("problem %s: %w", problemID, err)
+		}
+	}
+	return &p, nil
+}
+
+// GetProblemTests loads the tests of one version of a problem in order. It
+// returns false if the problem is gone or no longer at that version.
+func GetProblemTests(db *sqlx.DB, problemID string, version int) ([]problem.TestCase, bool, error) {
+	tx, err := db.BeginTxx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var current int
+	err = tx.Get(&current, `SELECT version FROM problems WHERE problem_id = $1`, problemID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && current != version) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var tests []problem.TestCase
+	err = tx.Select(&tests, `
+		SELECT test_index, input, expected_output, hidden, subtask_index
+		FROM problem_tests
+		WHERE problem_id = $1
+		ORDER BY test_index ASC`,
+		problemID)
+	if err != nil {
+		return nil, false, err
+	}
+	return tests, true, nil
+}
+
+// ProblemVersion names one version of a problem.
+type ProblemVersion struct {
+	ProblemID string `db:"problem_id"`
+	Version   int    `db:"version"`
+}
+
+// HotProblems returns the current versions of the problems most submitted
+// to within the given window, most submitted first.
+func HotProblems(db *sqlx.DB, window time.Duration, limit int) ([]ProblemVersion, error) {
+	var hot []ProblemVersion
+	err := db.Select(&hot, `
+		SELECT p.problem_id, p.version
+		FROM job_outbox o
+		JOIN problems p ON p.problem_id = o.payload->>'problem_id'
+		WHERE o.created_at > NOW() - make_interval(secs => $1)
+		GROUP BY p.problem_id, p.version
+		ORDER BY COUNT(*) DESC
+		LIMIT $2`,
+		window.Seconds(), limit)
+	return hot, err
+}
+
+func ProblemExists(db *sqlx.DB, problemID string) (bool, error) {
+	var id string
+	err := db.Get(&id, `
+		SELECT problem_id
+		FROM problems
+		WHERE problem_id = $1`,
+		problemID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetProblemVersion returns the problem's version without loading its
+// tests, or false if it does not exist.
+func GetProblemVersion(db *sqlx.DB, problemID string) (int, bool, error) {
+	var version int
+	err := db.Get(&version, `
+		SELECT version
+		FROM problems
+		WHERE problem_id = $1`,
+		problemID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
+}
@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"judge-worker/internal/job"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CachedResult is a verdict that can be reused for identical submissions.
+// SubmissionID names the submission it was judged for, whose per-test and
+// per-subtask rows are copied along with it.
+type CachedResult struct {
+	SubmissionID string
+	Result       job.ResultEvent
+}
+
+// GetCachedResult returns the cached result under key, or nil if there is
+// none.
+func GetCachedResult(db *sqlx.DB, key string) (*CachedResult, error) {
+	var row struct {
+		SubmissionID string `db:"submission_id"`
+		Result       []byte `db:"result"`
+	}
+	err := db.Get(&row, `
+		SELECT submission_id, result
+		FROM result_cache
+		WHERE cache_key = $1`,
+		key)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CachedResult{SubmissionID: row.SubmissionID}
+	if err := json.Unmarshal(row.Result, &c.Result); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SaveCachedResult caches a result unless one is already cached under key.
+func SaveCachedResult(db *sqlx.DB, key string, r *job.ResultEvent) error {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO result_cache (cache_key, submission_id, result)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (cache_key) DO NOTHING`,
+		key, r.SubmissionID, payload)
+	return err
+}
+
+// CopyJudgeDetails gives a submission the per-test results and subtask
+// scores recorded for another one.
+func CopyJudgeDetails(db *sqlx.DB, fromID, toID string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		`DELETE FROM submission_test_results WHERE submission_id = $2`,
+		`INSERT INTO submission_test_results
+		     (submission_id, test_index, problem_id, hidden, verdict, score, cpu_ms, wall_ms, peak_memory_kb, exit_code, diff)
+		 SELECT $2, test_index, problem_id, hidden, verdict, score, cpu_ms, wall_ms, peak_memory_kb, exit_code, diff
+		 FROM submission_test_results
+		 WHERE submission_id = $1`,
+		`DELETE FROM submission_subtask_scores WHERE submission_id = $2`,
+		`INSERT INTO submission_subtask_scores (submission_id, subtask_index, points, score)
+		 SELECT $2, subtask_index, points, score
+		 FROM submission_subtask_scores
+		 WHERE submission_id = $1`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, fromID, toID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
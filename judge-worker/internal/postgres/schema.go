@@ -24,6 +24,7 @@ CREATE TABLE IF NOT EXISTS job_outbox (
 );
 
 CREATE INDEX IF NOT EXISTS idx_job_outbox_status ON job_outbox(status) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_job_outbox_pending_user ON job_outbox(tier, user_id, id) WHERE status = 'pending';
 
 CREATE TABLE IF NOT EXISTS processed_jobs (
 	submission_id VARCHAR(255) PRIMARY KEY,
@@ -32,4 +33,128 @@ CREATE TABLE IF NOT EXISTS processed_jobs (
     result_payload JSONB, 
     result_saved_at TIMESTAMPTZ
 );
+
+ALTER TABLE job_outbox ADD COLUMN IF NOT EXISTS promoted_at TIMESTAMPTZ;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS promoted BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_job_outbox_aging ON job_outbox(created_at)
+    WHERE tier = 'free' AND promoted_at IS NULL AND status IN ('pending', 'published');
+
+CREATE TABLE IF NOT EXISTS build_artifacts (
+    submission_id VARCHAR(255) PRIMARY KEY,
+    data          BYTEA        NOT NULL,
+    created_at    TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS compile_claims (
+    submission_id VARCHAR(255) PRIMARY KEY,
+    message       VARCHAR(255) NOT NULL,   -- stream and ID of the claiming message
+    worker_id     VARCHAR(255) NOT NULL,
+    claimed_at    TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS problems (
+    problem_id      VARCHAR(255) PRIMARY KEY,
+    version         INT          NOT NULL DEFAULT 1,
+    time_limit_ms   INT          NOT NULL DEFAULT 2000,
+    memory_limit_kb INT          NOT NULL DEFAULT 262144,
+    created_at      TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS problem_tests (
+    problem_id      VARCHAR(255) NOT NULL REFERENCES problems(problem_id) ON DELETE CASCADE,
+    test_index      INT          NOT NULL,
+    input           TEXT         NOT NULL,
+    expected_output TEXT         NOT NULL,
+    hidden          BOOLEAN      NOT NULL DEFAULT TRUE,
+    PRIMARY KEY (problem_id, test_index)
+);
+
+CREATE TABLE IF NOT EXISTS submission_test_results (
+    submission_id  VARCHAR(255) NOT NULL,
+    test_index     INT          NOT NULL,
+    problem_id     VARCHAR(255) NOT NULL,
+    hidden         BOOLEAN      NOT NULL,
+    verdict        VARCHAR(50)  NOT NULL,
+    cpu_ms         INT          NOT NULL,
+    wall_ms        INT          NOT NULL,
+    peak_memory_kb BIGINT       NOT NULL,
+    exit_code      INT          NOT NULL,
+    PRIMARY KEY (submission_id, test_index)
+);
+
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS cpu_ms      INT    NOT NULL DEFAULT 0;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS wall_ms     INT    NOT NULL DEFAULT 0;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS peak_rss_kb BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS exit_code   INT    NOT NULL DEFAULT 0;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS term_signal INT    NOT NULL DEFAULT 0;
+
+ALTER TABLE problems ADD COLUMN IF NOT EXISTS checker          VARCHAR(50) NOT NULL DEFAULT 'exact';
+ALTER TABLE problems ADD COLUMN IF NOT EXISTS checker_language VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE problems ADD COLUMN IF NOT EXISTS checker_source   TEXT        NOT NULL DEFAULT '';
+
+ALTER TABLE submission_test_results ADD COLUMN IF NOT EXISTS score DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+ALTER TABLE problems ADD COLUMN IF NOT EXISTS interactive         BOOLEAN     NOT NULL DEFAULT FALSE;
+ALTER TABLE problems ADD COLUMN IF NOT EXISTS interactor_language VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE problems ADD COLUMN IF NOT EXISTS interactor_source   TEXT        NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS problem_subtasks (
+    problem_id    VARCHAR(255)     NOT NULL REFERENCES problems(problem_id) ON DELETE CASCADE,
+    subtask_index INT              NOT NULL,
+    points        DOUBLE PRECISION NOT NULL,
+    rule          VARCHAR(20)      NOT NULL DEFAULT 'all',
+    PRIMARY KEY (problem_id, subtask_index)
+);
+
+ALTER TABLE problem_tests ADD COLUMN IF NOT EXISTS subtask_index INT NOT NULL DEFAULT 0;
+
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS score DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS submission_subtask_scores (
+    submission_id VARCHAR(255)     NOT NULL,
+    subtask_index INT              NOT NULL,
+    points        DOUBLE PRECISION NOT NULL,
+    score         DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (submission_id, subtask_index)
+);
+
+CREATE TABLE IF NOT EXISTS submission_artifacts (
+    submission_id VARCHAR(255) NOT NULL,
+    name          VARCHAR(255) NOT NULL,
+    store_key     TEXT         NOT NULL,
+    size_bytes    INT          NOT NULL,
+    truncated     BOOLEAN      NOT NULL DEFAULT FALSE,
+    hidden        BOOLEAN      NOT NULL DEFAULT FALSE,
+    created_at    TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (submission_id, name)
+);
+
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS compile_output TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE submission_test_results ADD COLUMN IF NOT EXISTS diff JSONB;
+
+CREATE TABLE IF NOT EXISTS result_cache (
+    cache_key     CHAR(64)     PRIMARY KEY,
+    submission_id VARCHAR(255) NOT NULL,
+    result        JSONB        NOT NULL,
+    created_at    TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS cached BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS blobs (
+    hash       CHAR(64)    PRIMARY KEY,   -- hex SHA-256 of data
+    data       BYTEA       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_blobs_created_at ON blobs(created_at);
+CREATE INDEX IF NOT EXISTS idx_job_outbox_source_hash ON job_outbox((payload->>'source_hash'));
+
+CREATE INDEX IF NOT EXISTS idx_job_outbox_created_at ON job_outbox(created_at);
+
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS time_limit_ms   INT    NOT NULL DEFAULT 0;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS wall_limit_ms   INT    NOT NULL DEFAULT 0;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS memory_limit_kb BIGINT NOT NULL DEFAULT 0;
 `
@@ -1,19 +1,52 @@
 package postgres
 
 import (
+	"database/sql"
+	"errors"
 	"judge-worker/internal/job"
 
 	"github.com/jmoiron/sqlx"
 )
 
-func InsertResultEvent(db *sqlx.DB, r *job.ResultEvent) error {
-	_, err := db.NamedExec(`
+// InsertResultEvent records the final result of a submission. Callers
+// retire its outbox entry with MarkOutboxEntryCompleted.
+//
+// Whether the submission was promoted is taken from its outbox entry: the
+// copy left in the free stream may be the one that ran, and it does not
+// know about the promotion.
+func InsertResultEvent(db sqlx.Ext, r *job.ResultEvent) error {
+	_, err := sqlx.NamedExec(db, `
 		INSERT INTO submissions
-		    (submission_id, user_id, tier, language, execution_ms, status, completed_at)
+		    (submission_id, user_id, tier, language, execution_ms, status, completed_at, promoted,
+		     cpu_ms, wall_ms, peak_rss_kb, exit_code, term_signal, score, compile_output, cached,
+		     time_limit_ms, wall_limit_ms, memory_limit_kb)
 		VALUES
-		    (:submission_id, :user_id, :tier, :language, :execution_ms, :status, :completed_at)
+		    (:submission_id, :user_id, :tier, :language, :execution_ms, :status, :completed_at,
+		     COALESCE((SELECT promoted_at IS NOT NULL FROM job_outbox WHERE submission_id = :submission_id), :promoted),
+		     :cpu_ms, :wall_ms, :peak_rss_kb, :exit_code, :term_signal, :score, :compile_output, :cached,
+		     :time_limit_ms, :wall_limit_ms, :memory_limit_kb)
 		ON CONFLICT (submission_id) DO NOTHING`,
 		r,
 	)
 	return err
 }
+
+func GetSubmission(db *sqlx.DB, submissionID string) (*job.ResultEvent, error) {
+	var r job.ResultEvent
+	err := db.Get(&r, `
+		SELECT submission_id, user_id, tier, language, execution_ms, status, completed_at, promoted,
+		       cpu_ms, wall_ms, peak_rss_kb, exit_code, term_signal, score, compile_output, cached,
+		       time_limit_ms, wall_limit_ms, memory_limit_kb
+		FROM submissions
+		WHERE submission_id = $1`,
+		submissionID,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
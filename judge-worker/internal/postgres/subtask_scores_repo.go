@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"judge-worker/internal/job"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SaveSubtaskScores replaces the stored subtask breakdown of a submission.
+func SaveSubtaskScores(db *sqlx.DB, submissionID string, scores []job.SubtaskScore) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM submission_subtask_scores WHERE submission_id = $1`, submissionID); err != nil {
+		return err
+	}
+
+	for i := range scores {
+		scores[i].SubmissionID = submissionID
+		_, err := tx.NamedExec(`
+			INSERT INTO submission_subtask_scores (submission_id, subtask_index, points, score)
+			VALUES (:submission_id, :subtask_index, :points, :score)`,
+			&scores[i],
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func GetSubtaskScores(db *sqlx.DB, submissionID string) ([]job.SubtaskScore, error) {
+	var scores []job.SubtaskScore
+	err := db.Select(&scores, `
+		SELECT submission_id, subtask_index, points, score
+		FROM submission_subtask_scores
+		WHERE submission_id = $1
+		ORDER BY subtask_index ASC`,
+		submissionID)
+
+	return scores, err
+}
@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"judge-worker/internal/job"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TestResultDetail is a stored test result together with the test case it
+// ran, as served by the API.
+type TestResultDetail struct {
+	job.TestResult
+	Input          string `db:"input"`
+	ExpectedOutput string `db:"expected_output"`
+}
+
+// SaveTestResults replaces the stored test results of a submission, so a
+// rejudge never leaves rows from the previous run behind.
+func SaveTestResults(db *sqlx.DB, submissionID string, results []job.TestResult) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM submission_test_results WHERE submission_id = $1`, submissionID); err != nil {
+		return err
+	}
+
+	for i := range results {
+		results[i].SubmissionID = submissionID
+		_, err := tx.NamedExec(`
+			INSERT INTO submission_test_results
+			    (submission_id, test_index, problem_id, hidden, verdict, score, cpu_ms, wall_ms, peak_memory_kb, exit_code, diff)
+			VALUES
+			    (:submission_id, :test_index, :problem_id, :hidden, :verdict, :score, :cpu_ms, :wall_ms, :peak_memory_kb, :exit_code, :diff)`,
+			&results[i],
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func GetTestResults(db *sqlx.DB, submissionID string) ([]TestResultDetail, error) {
+	var results []TestResultDetail
+	err := db.Select(&results, `
+		SELECT r.submission_id, r.test_index, r.problem_id, r.hidden, r.verdict, r.score,
+		       r.cpu_ms, r.wall_ms, r.peak_memory_kb, r.exit_code, r.diff,
+		       COALESCE(t.input, '') AS input, COALESCE(t.expected_output, '') AS expected_output
+		FROM submission_test_results r
+		LEFT JOIN problem_tests t ON t.problem_id = r.problem_id AND t.test_index = r.test_index
+		WHERE r.submission_id = $1
+		ORDER BY r.test_index ASC`,
+		submissionID)
+
+	return results, err
+}
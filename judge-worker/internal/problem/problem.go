@@ -0,0 +1,94 @@
+package problem
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Problem is what a submission is judged against: base resource limits and
+// the ordered test cases. Version changes whenever tests or limits change.
+type Problem struct {
+	ID            string `db:"problem_id" json:"problem_id"`
+	Version       int    `db:"version" json:"version"`
+	TimeLimitMs   int    `db:"time_limit_ms" json:"time_limit_ms"`
+	MemoryLimitKB int    `db:"memory_limit_kb" json:"memory_limit_kb"`
+
+	// Checker names a built-in checker, or "custom" to run CheckerSource
+	// written in CheckerLanguage.
+	Checker         string `db:"checker" json:"checker"`
+	CheckerLanguage string `db:"checker_language" json:"checker_language,omitempty"`
+	CheckerSource   string `db:"checker_source" json:"-"`
+
+	// Interactive problems are judged by running InteractorSource next to
+	// the submission with their stdin and stdout connected; the checker is
+	// not used.
+	Interactive        bool   `db:"interactive" json:"interactive"`
+	InteractorLanguage string `db:"interactor_language" json:"interactor_language,omitempty"`
+	InteractorSource   string `db:"interactor_source" json:"-"`
+
+	Tests    []TestCase
+	Subtasks []Subtask
+}
+
+type TestCase struct {
+	Index          int    `db:"test_index" json:"test_index"`
+	Input          string `db:"input" json:"input"`
+	ExpectedOutput string `db:"expected_output" json:"expected_output"`
+	Hidden         bool   `db:"hidden" json:"hidden"`
+	Subtask        int    `db:"subtask_index" json:"subtask_index"`
+}
+
+// Scoring rules of a subtask, applied to the scores (0 to 1) of its tests.
+const (
+	// RuleAllOrNothing awards the points only if every test scored fully.
+	RuleAllOrNothing = "all"
+	// RuleMin awards the points scaled by the lowest test score.
+	RuleMin = "min"
+	// RuleSum splits the points evenly between the tests.
+	RuleSum = "sum"
+)
+
+// Subtask is a group of tests worth Points. Tests belong to the subtask
+// whose index they carry; tests of an index without a subtask score nothing.
+type Subtask struct {
+	Index  int     `db:"subtask_index" json:"subtask_index"`
+	Points float64 `db:"points" json:"points"`
+	Rule   string  `db:"rule" json:"rule"`
+}
+
+// Validate rejects subtasks whose rule is not one of the scoring rules, which
+// Score would otherwise treat as all-or-nothing.
+func (s Subtask) Validate() error {
+	switch s.Rule {
+	case RuleAllOrNothing, RuleMin, RuleSum:
+		return nil
+	}
+	return fmt.Errorf("subtask %d: unknown scoring rule %q", s.Index, s.Rule)
+}
+
+// Score applies the subtask's rule to the scores of its tests. A subtask
+// without tests scores nothing. Rules are checked by Validate when the
+// problem is loaded.
+func (s Subtask) Score(tests []float64) float64 {
+	if len(tests) == 0 {
+		return 0
+	}
+
+	switch s.Rule {
+	case RuleMin:
+		return s.Points * min(1, slices.Min(tests))
+	case RuleSum:
+		var sum float64
+		for _, t := range tests {
+			sum += t
+		}
+		return s.Points * sum / float64(len(tests))
+	default:
+		for _, t := range tests {
+			if t < 1 {
+				return 0
+			}
+		}
+		return s.Points
+	}
+}
@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWeightedTurns(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		want    []int
+	}{
+		{"single queue", []int{5}, []int{0, 0, 0}},
+		{"equal weights alternate", []int{1, 1}, []int{0, 1, 0, 1}},
+		{"3:1 is smooth", []int{3, 1}, []int{0, 0, 1, 0, 0, 0, 1, 0}},
+		{"1:3 favours the second", []int{1, 3}, []int{1, 0, 1, 1}},
+		{"three queues", []int{2, 1, 1}, []int{0, 1, 2, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWeighted(tt.weights)
+			var got []int
+			for range tt.want {
+				got = append(got, w.Order()[0])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("turns = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		want    [][]int
+	}{
+		{"fallback by weight", []int{1, 3, 2}, [][]int{{1, 2, 0}, {2, 1, 0}, {0, 1, 2}}},
+		{"every queue listed once", []int{1, 1, 1}, [][]int{{0, 1, 2}, {1, 0, 2}, {2, 0, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWeighted(tt.weights)
+			for i, want := range tt.want {
+				if got := w.Order(); !reflect.DeepEqual(got, want) {
+					t.Errorf("order %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedShares(t *testing.T) {
+	tests := []struct {
+		weights []int
+		rounds  int
+	}{
+		{[]int{3, 1}, 4},
+		{[]int{5, 2, 1}, 10},
+		{[]int{1, 0}, 3},
+	}
+
+	for _, tt := range tests {
+		w := NewWeighted(tt.weights)
+		total := 0
+		for _, wt := range tt.weights {
+			total += wt
+		}
+
+		counts := make([]int, len(tt.weights))
+		for i := 0; i < tt.rounds*total; i++ {
+			counts[w.Order()[0]]++
+		}
+		for i, wt := range tt.weights {
+			if counts[i] != wt*tt.rounds {
+				t.Errorf("weights %v: queue %d got %d turns, want %d", tt.weights, i, counts[i], wt*tt.rounds)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package scheduler
+
+// Weighted hands out turns between a fixed set of queues in proportion to
+// their weights using smooth weighted round-robin, so a 3:1 split becomes
+// A A B A rather than A A A B.
+type Weighted struct {
+	weights []int
+	current []int
+	total   int
+}
+
+func NewWeighted(weights []int) *Weighted {
+	w := &Weighted{
+		weights: weights,
+		current: make([]int, len(weights)),
+	}
+	for _, wt := range weights {
+		w.total += wt
+	}
+	return w
+}
+
+// Order returns every queue index, starting with the one whose turn it is
+// and followed by the others by descending weight. Callers try them in that
+// order so a turn is never wasted on an empty queue.
+func (w *Weighted) Order() []int {
+	best := 0
+	for i, wt := range w.weights {
+		w.current[i] += wt
+		if w.current[i] > w.current[best] {
+			best = i
+		}
+	}
+	w.current[best] -= w.total
+
+	order := make([]int, 0, len(w.weights))
+	order = append(order, best)
+	for len(order) < len(w.weights) {
+		next := -1
+		for i := range w.weights {
+			if contains(order, i) {
+				continue
+			}
+			if next == -1 || w.weights[i] > w.weights[next] {
+				next = i
+			}
+		}
+		order = append(order, next)
+	}
+	return order
+}
+
+func contains(xs []int, x int) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
@@ -11,6 +11,42 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// JobStream and JobGroup name the job stream of a worker pool and the
+// consumer group its workers read it through. Languages with a dedicated
+// pool get their own stream per tier, such as free-python-stream; an empty
+// language names the tier's general pool, such as free-stream.
+func JobStream(tier, language string) string {
+	if language == "" {
+		return tier + "-stream"
+	}
+	return tier + "-" + language + "-stream"
+}
+
+func JobGroup(tier, language string) string {
+	if language == "" {
+		return "workers-" + tier
+	}
+	return "workers-" + tier + "-" + language
+}
+
+// RunStream and RunGroup name the stream that carries compiled submissions
+// of a pool from the compile stage to the run stage.
+func RunStream(tier, language string) string {
+	return strings.TrimSuffix(JobStream(tier, language), "-stream") + "-run-stream"
+}
+
+func RunGroup(tier, language string) string {
+	return JobGroup(tier, language) + "-run"
+}
+
+// Job messages carry the serialised job in PayloadField. Payloads too large
+// to copy into redis are stored as a blob instead, and the message only
+// carries its hash in PayloadRefField.
+const (
+	PayloadField    = "payload"
+	PayloadRefField = "payload_ref"
+)
+
 func EnsureConsumerGroup(ctx context.Context, rdb *redis.Client, stream, group string) error {
 	err := rdb.XGroupCreateMkStream(ctx, stream, group, "0").Err()
 	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
@@ -19,17 +55,45 @@ func EnsureConsumerGroup(ctx context.Context, rdb *redis.Client, stream, group s
 	return nil
 }
 
+// Backlog reports how many entries of stream have not been delivered to
+// group yet, or -1 when redis cannot tell.
+func Backlog(ctx context.Context, rdb *redis.Client, stream, group string) (int64, error) {
+	groups, err := rdb.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		return 0, err
+	}
+	for _, g := range groups {
+		if g.Name == group {
+			return g.Lag, nil
+		}
+	}
+	return -1, nil
+}
+
 func PublishResult(ctx context.Context, rdb *redis.Client, result *job.ResultEvent) error {
 	_, err := rdb.XAdd(ctx, &redis.XAddArgs{
 		Stream: "submission",
 		Values: map[string]interface{}{
-			"submission_id": result.SubmissionID,
-			"user_id":       result.UserID,
-			"tier":          result.Tier,
-			"language":      result.Language,
-			"execution_ms":  result.ExecutionMs,
-			"status":        result.Status,
-			"completed_at":  result.CompletedAt.Format(time.RFC3339),
+			"submission_id":  result.SubmissionID,
+			"user_id":        result.UserID,
+			"tier":           result.Tier,
+			"language":       result.Language,
+			"execution_ms":   result.ExecutionMs,
+			"cpu_ms":         result.CPUMs,
+			"wall_ms":        result.WallMs,
+			"peak_rss_kb":    result.PeakRSSKB,
+			"exit_code":      result.ExitCode,
+			"signal":         result.Signal,
+			"status":         result.Status,
+			"score":          strconv.FormatFloat(result.Score, 'f', -1, 64),
+			"compile_output": result.CompileOutput,
+			"completed_at":   result.CompletedAt.Format(time.RFC3339),
+			"promoted":       strconv.FormatBool(result.Promoted),
+			"cached":         strconv.FormatBool(result.Cached),
+
+			"time_limit_ms":   result.TimeLimitMs,
+			"wall_limit_ms":   result.WallLimitMs,
+			"memory_limit_kb": result.MemoryLimitKB,
 		},
 		ID: "*",
 	}).Result()
@@ -45,18 +109,41 @@ func MapToResultEvent(values map[string]interface{}) (*job.ResultEvent, error) {
 	}
 
 	execMs, _ := strconv.Atoi(getStr("execution_ms"))
+	cpuMs, _ := strconv.Atoi(getStr("cpu_ms"))
+	wallMs, _ := strconv.Atoi(getStr("wall_ms"))
+	peakRSS, _ := strconv.ParseInt(getStr("peak_rss_kb"), 10, 64)
+	exitCode, _ := strconv.Atoi(getStr("exit_code"))
+	signal, _ := strconv.Atoi(getStr("signal"))
+	promoted, _ := strconv.ParseBool(getStr("promoted"))
+	cached, _ := strconv.ParseBool(getStr("cached"))
+	score, _ := strconv.ParseFloat(getStr("score"), 64)
+	timeLimit, _ := strconv.Atoi(getStr("time_limit_ms"))
+	wallLimit, _ := strconv.Atoi(getStr("wall_limit_ms"))
+	memoryLimit, _ := strconv.ParseInt(getStr("memory_limit_kb"), 10, 64)
 	completedAt, err := time.Parse(time.RFC3339, getStr("completed_at"))
 	if err != nil {
 		completedAt = time.Now()
 	}
 
 	return &job.ResultEvent{
-		SubmissionID: getStr("submission_id"),
-		UserID:       getStr("user_id"),
-		Tier:         getStr("tier"),
-		Language:     getStr("language"),
-		ExecutionMs:  execMs,
-		Status:       getStr("status"),
-		CompletedAt:  completedAt,
+		SubmissionID:  getStr("submission_id"),
+		UserID:        getStr("user_id"),
+		Tier:          getStr("tier"),
+		Language:      getStr("language"),
+		ExecutionMs:   execMs,
+		CPUMs:         cpuMs,
+		WallMs:        wallMs,
+		PeakRSSKB:     peakRSS,
+		ExitCode:      exitCode,
+		Signal:        signal,
+		Status:        getStr("status"),
+		Score:         score,
+		CompileOutput: getStr("compile_output"),
+		CompletedAt:   completedAt,
+		Promoted:      promoted,
+		Cached:        cached,
+		TimeLimitMs:   timeLimit,
+		WallLimitMs:   wallLimit,
+		MemoryLimitKB: memoryLimit,
 	}, nil
 }
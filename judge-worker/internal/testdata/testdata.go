@@ -0,0 +1,254 @@
+package testdata
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"judge-worker/internal/problem"
+)
+
+// Counters of all caches in the process, served on /debug/vars.
+var (
+	hits      = expvar.NewInt("testdata_cache_hits")
+	misses    = expvar.NewInt("testdata_cache_misses")
+	corrupt   = expvar.NewInt("testdata_cache_corrupt")
+	evictions = expvar.NewInt("testdata_cache_evictions")
+)
+
+func init() {
+	expvar.Publish("testdata_cache_hit_rate", expvar.Func(func() any {
+		h, m := hits.Value(), misses.Value()
+		if h+m == 0 {
+			return 0.0
+		}
+		return float64(h) / float64(h+m)
+	}))
+}
+
+// Key is the version hash a problem's tests are cached under. Versions
+// change whenever tests change, so an entry never goes stale.
+func Key(problemID string, version int) string {
+	sum := sha256.Sum256([]byte(problemID + "\x00" + strconv.Itoa(version)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Cache keeps test sets on local disk, evicting the least recently used
+// ones once their total size exceeds the limit. Every file starts with the
+// SHA-256 of its contents, and entries that fail the check are dropped.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+// Open uses dir as a cache of at most maxBytes. Entries left by an earlier
+// run are kept, oldest first in line for eviction.
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type found struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var existing []found
+	for _, de := range des {
+		info, err := de.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if strings.HasPrefix(de.Name(), ".") {
+			// An interrupted write.
+			os.Remove(filepath.Join(dir, de.Name()))
+			continue
+		}
+		existing = append(existing, found{de.Name(), info.Size(), info.ModTime()})
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].modTime.Before(existing[j].modTime) })
+
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	for _, f := range existing {
+		c.entries[f.key] = c.order.PushFront(&entry{key: f.key, size: f.size})
+		c.size += f.size
+	}
+	c.evict()
+	return c, nil
+}
+
+// Get returns the tests cached under key.
+func (c *Cache) Get(key string) ([]problem.TestCase, bool) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if ok {
+		c.order.MoveToFront(el)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		misses.Add(1)
+		return nil, false
+	}
+
+	// The file is read without holding mu, so it may be evicted or
+	// replaced meanwhile. A vanished file is a plain miss, and only the
+	// entry that was read is dropped, never one Put in the meantime.
+	path := filepath.Join(c.dir, key)
+	tests, err := read(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			corrupt.Add(1)
+		}
+		misses.Add(1)
+		c.remove(el)
+		return nil, false
+	}
+
+	// The modification time orders entries after a restart.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	hits.Add(1)
+	return tests, true
+}
+
+// Contains reports whether key is cached, without counting as a use.
+func (c *Cache) Contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[key]
+	return ok
+}
+
+// Put caches tests under key. Test sets larger than the whole cache are not
+// kept.
+func (c *Cache) Put(key string, tests []problem.TestCase) error {
+	body, err := json.Marshal(tests)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+
+	var buf bytes.Buffer
+	buf.WriteString(hex.EncodeToString(sum[:]))
+	buf.WriteByte('\n')
+	buf.Write(body)
+
+	size := int64(buf.Len())
+	if size > c.maxBytes {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(c.dir, ".put-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*entry).size
+		c.order.Remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, size: size})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+// remove drops el and its file if el is still the entry of its key.
+func (c *Cache) remove(el *list.Element) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := el.Value.(*entry)
+	if c.entries[e.key] != el {
+		return
+	}
+	c.size -= e.size
+	c.order.Remove(el)
+	delete(c.entries, e.key)
+	os.Remove(filepath.Join(c.dir, e.key))
+}
+
+// evict drops the least recently used entries until the cache fits. c.mu
+// must be held.
+func (c *Cache) evict() {
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		e := oldest.Value.(*entry)
+		c.order.Remove(oldest)
+		delete(c.entries, e.key)
+		c.size -= e.size
+		os.Remove(filepath.Join(c.dir, e.key))
+		evictions.Add(1)
+	}
+}
+
+// read loads a cached test set and verifies it against its checksum.
+func read(path string) ([]problem.TestCase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, body, ok := bytes.Cut(data, []byte{'\n'})
+	if !ok {
+		return nil, errors.New("testdata: missing checksum")
+	}
+	actual := sha256.Sum256(body)
+	if hex.EncodeToString(actual[:]) != string(sum) {
+		return nil, fmt.Errorf("testdata: %s: checksum mismatch", filepath.Base(path))
+	}
+
+	var tests []problem.TestCase
+	if err := json.Unmarshal(body, &tests); err != nil {
+		return nil, err
+	}
+	return tests, nil
+}
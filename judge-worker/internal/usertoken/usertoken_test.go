@@ -0,0 +1,74 @@
+package usertoken
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1_700_000_000, 0)
+	valid := Sign(secret, "alice", now.Add(time.Hour))
+
+	claims, sig, _ := cutLast(valid, ".")
+	flipped := []byte(sig)
+	if flipped[0] == '0' {
+		flipped[0] = '1'
+	} else {
+		flipped[0] = '0'
+	}
+	otherUser, _, _ := cutLast(Sign(secret, "mallory", now.Add(time.Hour)), ".")
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		token   string
+		now     time.Time
+		want    string
+		wantErr error
+	}{
+		{"valid", secret, valid, now, "alice", nil},
+		{"user ID with dots", secret, Sign(secret, "team.alice", now.Add(time.Hour)), now, "team.alice", nil},
+		{"just before expiry", secret, valid, now.Add(time.Hour - time.Second), "alice", nil},
+		{"at expiry", secret, valid, now.Add(time.Hour), "", ErrExpired},
+		{"expired", secret, valid, now.Add(2 * time.Hour), "", ErrExpired},
+		{"wrong secret", []byte("other"), valid, now, "", ErrSignature},
+		{"tampered signature", secret, claims + "." + string(flipped), now, "", ErrSignature},
+		{"other user's claims with this signature", secret, otherUser + "." + sig, now, "", ErrSignature},
+		{"extended expiry", secret, strings.Replace(valid, ".1700003600.", ".1800000000.", 1), now, "", ErrSignature},
+		{"no signature", secret, claims, now, "", ErrSignature},
+		{"signature not hex", secret, claims + ".zz", now, "", ErrMalformed},
+		{"empty", secret, "", now, "", ErrMalformed},
+		{"empty user", secret, Sign(secret, "", now.Add(time.Hour)), now, "", ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(tt.secret, tt.token, tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Verify = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignIsBoundToUser(t *testing.T) {
+	secret := []byte("secret")
+	expires := time.Unix(1_700_003_600, 0)
+	if Sign(secret, "alice", expires) == Sign(secret, "bob", expires) {
+		t.Fatal("tokens of different users are equal")
+	}
+
+	user, err := Verify(secret, Sign(secret, "bob", expires), expires.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "bob" {
+		t.Errorf("bob's token verified as %q", user)
+	}
+}
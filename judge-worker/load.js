@@ -25,7 +25,8 @@ export default function () {
         submission_id: randomString(8),
         user_id: 'user_' + randomString(5),
         language: 'python',
-        tier: tier
+        tier: tier,
+        source: 'print(sum(range(1000)))\n'
     });
 
     const params = {